@@ -3,10 +3,12 @@ package main
 import (
 	"certmonitor/monitor"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,20 +16,46 @@ var (
 	validateParamHost   *regexp.Regexp
 	validateParamSNI    *regexp.Regexp
 	validateParamNumber *regexp.Regexp
+	validateStateScan   *regexp.Regexp
+	validateStatePin    *regexp.Regexp
+	validateStateHist   *regexp.Regexp
+	validateParamHashes *regexp.Regexp
+	validateParamAlg    *regexp.Regexp
+	validateParamDomain *regexp.Regexp
+	validateCertFinding *regexp.Regexp
+	validateCertDown    *regexp.Regexp
+	validateStateChain  *regexp.Regexp
+	validateStateFind   *regexp.Regexp
 	httpSrv             *http.Server
 	httpMux             *http.ServeMux
 )
 
 func init() {
-	validateParamHost, _ = regexp.Compile("[A-Za-z\\d\\.\\-]{1,}:\\d{2,}")
-	validateParamSNI, _ = regexp.Compile("[A-Za-z\\d\\.\\-]{1,}")
+	validateParamHost, _ = regexp.Compile("^([A-Za-z\\d\\.\\-]{1,}|\\[[A-Fa-f\\d:\\.]{2,}\\]):\\d{2,5}$")
+	validateParamSNI, _ = regexp.Compile("^([A-Za-z\\d\\.\\-]{1,}|[A-Fa-f\\d:\\.]{2,})$")
 	validateParamNumber, _ = regexp.Compile("\\d*")
+	validateStateScan, _ = regexp.Compile("^/states/(\\d+)/scan$")
+	validateStatePin, _ = regexp.Compile("^/states/(\\d+)/pin$")
+	validateStateHist, _ = regexp.Compile("^/states/(\\d+)/history$")
+	validateParamDomain, _ = regexp.Compile("^(\\*\\.)?[A-Za-z\\d\\.\\-]{1,253}$")
+	validateParamAlg, _ = regexp.Compile("^[A-Za-z\\d\\-]*$")
+	validateParamHashes, _ = regexp.Compile("^[A-Fa-f\\d]{40,64}(,[A-Fa-f\\d]{40,64})*$")
+	validateCertFinding, _ = regexp.Compile("^/certs/(\\d+)/findings$")
+	validateCertDown, _ = regexp.Compile("^/certs/(\\d+)/download$")
+	validateStateChain, _ = regexp.Compile("^/states/(\\d+)/chain$")
+	validateStateFind, _ = regexp.Compile("^/states/(\\d+)/findings$")
 
 	httpMux = &http.ServeMux{}
 	httpMux.HandleFunc("/check", onCheck)
 	httpMux.HandleFunc("/certs", onCerts)
+	httpMux.HandleFunc("/certs/", onCertItem)
 	httpMux.HandleFunc("/states", onStates)
+	httpMux.HandleFunc("/states/", onStateItem)
 	httpMux.HandleFunc("/statecerts", onStateCerts)
+	httpMux.HandleFunc("/search", onSearch)
+	httpMux.HandleFunc("/blast", onBlast)
+	httpMux.HandleFunc("/cagraph", onCAGraph)
+	httpMux.HandleFunc("/simulate", onSimulate)
 	fs := http.FileServer(http.Dir("ui"))
 	httpMux.Handle("/", fs)
 }
@@ -57,7 +85,12 @@ func onCheck(w http.ResponseWriter, r *http.Request) {
 		if !validateParamSNI.MatchString(sni) {
 			sni = ""
 		}
-		state := monitor.NewState(host, sni)
+		proto := getSingleQueryParam(r, "proto")
+		if len(proto) != 0 && !monitor.ValidProtocol(proto) {
+			replyBadRequest(w, r)
+			return
+		}
+		state := monitor.NewState(host, sni, proto)
 		certmon.UpdateState(state)
 		json.NewEncoder(w).Encode(state)
 	} else {
@@ -76,23 +109,108 @@ func onCerts(w http.ResponseWriter, r *http.Request) {
 		if len(id) != 0 {
 			value, _ := strconv.Atoi(id)
 			cert := certmon.DB.GetCertificateByID(value)
+			if cert != nil {
+				certs := []monitor.DBCertRow{*cert}
+				certmon.CertSeverity(certs)
+				cert = &certs[0]
+			}
 			json.NewEncoder(w).Encode(cert)
 			return
 		}
 		if len(expire) != 0 {
 			value, _ := strconv.Atoi(expire)
 			certs := certmon.DB.GetCertificatesByExpire(value)
+			certmon.CertSeverity(certs)
 			json.NewEncoder(w).Encode(certs)
 			return
 		}
 
-		certs := certmon.DB.GetCertificatesBy("")
+		if class := getSingleQueryParam(r, "class"); len(class) != 0 {
+			if !monitor.ValidCertClass(class) {
+				replyBadRequest(w, r)
+				return
+			}
+			certs := certmon.DB.GetCertificatesByClass(class)
+			certmon.CertSeverity(certs)
+			json.NewEncoder(w).Encode(certs)
+			return
+		}
+		algorithm := getSingleQueryParam(r, "keyAlgorithm")
+		size := getSingleQueryParam(r, "keySize")
+		issuer := getSingleQueryParam(r, "issuer")
+		if !validateParamAlg.MatchString(algorithm) || !validateParamNumber.MatchString(size) ||
+			(len(issuer) != 0 && !validateParamHashes.MatchString(issuer)) {
+			replyBadRequest(w, r)
+			return
+		}
+		keySize, _ := strconv.Atoi(size)
+		certs := certmon.DB.GetCertificatesByKey(algorithm, keySize, issuer)
+		certmon.CertSeverity(certs)
 		json.NewEncoder(w).Encode(certs)
 	} else {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
+func onCertItem(w http.ResponseWriter, r *http.Request) {
+	if match := validateCertDown.FindStringSubmatch(r.URL.Path); match != nil {
+		id, _ := strconv.Atoi(match[1])
+		replyCertificates(w, r, certmon.DB.GetCertificateDERByID(id), fmt.Sprintf("cert-%d", id))
+		return
+	}
+	onCertFindings(w, r)
+}
+
+// replyCertificates sends the certificates encoded to the requested format, PEM by default
+func replyCertificates(w http.ResponseWriter, r *http.Request, ders [][]byte, name string) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	format := getSingleQueryParam(r, "format")
+	if len(format) == 0 {
+		format = monitor.FormatPEM
+	}
+	if !monitor.ValidFormat(format) {
+		replyBadRequest(w, r)
+		return
+	}
+	if len(ders) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	data, err := monitor.EncodeCertificates(ders, format)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", monitor.FormatContentType(format))
+	if format != monitor.FormatText {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", name, format))
+	}
+	w.Write(data)
+}
+
+func onCertFindings(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		match := validateCertFinding.FindStringSubmatch(r.URL.Path)
+		if match == nil {
+			http.NotFound(w, r)
+			return
+		}
+		id, _ := strconv.Atoi(match[1])
+		if certmon.DB.GetCertificateByID(id) == nil {
+			http.NotFound(w, r)
+			return
+		}
+		findings := certmon.DB.GetFindingsByCertID(id)
+		json.NewEncoder(w).Encode(findings)
+	} else {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func onStates(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		id := getSingleQueryParam(r, "id")
@@ -113,6 +231,38 @@ func onStates(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(states)
 			return
 		}
+		if severity := getSingleQueryParam(r, "severity"); len(severity) != 0 {
+			if !monitor.ValidSeverity(severity) {
+				replyBadRequest(w, r)
+				return
+			}
+			states := certmon.DB.GetStatesBySeverity(severity)
+			json.NewEncoder(w).Encode(states)
+			return
+		}
+		if getSingleQueryParam(r, "distrusted") == "1" {
+			states := certmon.DB.GetDistrustedStates()
+			json.NewEncoder(w).Encode(states)
+			return
+		}
+		if status := getSingleQueryParam(r, "dane"); len(status) != 0 {
+			if !monitor.ValidDANEStatus(status) {
+				replyBadRequest(w, r)
+				return
+			}
+			states := certmon.DB.GetStatesByDANE(status)
+			json.NewEncoder(w).Encode(states)
+			return
+		}
+		if category := getSingleQueryParam(r, "error"); len(category) != 0 {
+			if !monitor.ValidErrorCategory(category) {
+				replyBadRequest(w, r)
+				return
+			}
+			states := certmon.DB.GetStatesByError(category)
+			json.NewEncoder(w).Encode(states)
+			return
+		}
 		states := certmon.DB.GetStatesBy("")
 		json.NewEncoder(w).Encode(states)
 	} else if r.Method == http.MethodPost {
@@ -125,8 +275,14 @@ func onStates(w http.ResponseWriter, r *http.Request) {
 		if !validateParamSNI.MatchString(sni) {
 			sni = ""
 		}
-		state := monitor.NewState(host, sni)
+		proto := getSingleQueryParam(r, "proto")
+		if len(proto) != 0 && !monitor.ValidProtocol(proto) {
+			replyBadRequest(w, r)
+			return
+		}
+		state := monitor.NewState(host, sni, proto)
 		state.Type = monitor.CustomState
+		state.Scan = getSingleQueryParam(r, "scan") == "1"
 		if err := certmon.DB.InsertState(*state); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 		} else {
@@ -137,6 +293,130 @@ func onStates(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func onStateItem(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case validateStatePin.MatchString(r.URL.Path):
+		onStatePin(w, r)
+	case validateStateHist.MatchString(r.URL.Path):
+		onStateHistory(w, r)
+	case validateStateFind.MatchString(r.URL.Path):
+		onStateFindings(w, r)
+	case validateStateChain.MatchString(r.URL.Path):
+		match := validateStateChain.FindStringSubmatch(r.URL.Path)
+		id, _ := strconv.Atoi(match[1])
+		replyCertificates(w, r, certmon.DB.GetChainDERByStateID(id), fmt.Sprintf("chain-%d", id))
+	default:
+		onStateScan(w, r)
+	}
+}
+
+func onStateFindings(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		match := validateStateFind.FindStringSubmatch(r.URL.Path)
+		id, _ := strconv.Atoi(match[1])
+		if certmon.DB.GetStateByID(id) == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(certmon.DB.GetFindingsByStateID(id))
+	} else {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func onStateHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		match := validateStateHist.FindStringSubmatch(r.URL.Path)
+		id, _ := strconv.Atoi(match[1])
+		if certmon.DB.GetStateByID(id) == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(certmon.DB.GetTimelineByStateID(id))
+	} else {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// splitParam splits comma separated list of the query parameter
+func splitParam(r *http.Request, name string) []string {
+	if param := getSingleQueryParam(r, name); len(param) != 0 {
+		return strings.Split(param, ",")
+	}
+	return nil
+}
+
+func onStatePin(w http.ResponseWriter, r *http.Request) {
+	match := validateStatePin.FindStringSubmatch(r.URL.Path)
+	id, _ := strconv.Atoi(match[1])
+	if certmon.DB.GetStateByID(id) == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		pin := certmon.DB.GetPinByStateID(id)
+		if pin == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(pin)
+	case http.MethodPost:
+		pin := monitor.DBPinRow{StateID: id}
+		pin.SPKIHashes = splitParam(r, "spki")
+		pin.IssuerHashes = splitParam(r, "issuer")
+		pin.Domains = splitParam(r, "domain")
+		for _, hashes := range [][]string{pin.SPKIHashes, pin.IssuerHashes} {
+			if len(hashes) != 0 && !validateParamHashes.MatchString(strings.Join(hashes, ",")) {
+				replyBadRequest(w, r)
+				return
+			}
+		}
+		for _, domain := range pin.Domains {
+			if !validateParamSNI.MatchString(domain) {
+				replyBadRequest(w, r)
+				return
+			}
+		}
+		if pin.Empty() {
+			replyBadRequest(w, r)
+			return
+		}
+		if err := certmon.DB.InsertPin(pin); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusAccepted)
+		}
+	case http.MethodDelete:
+		if err := certmon.DB.DeletePin(id); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusAccepted)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func onStateScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		match := validateStateScan.FindStringSubmatch(r.URL.Path)
+		if match == nil {
+			http.NotFound(w, r)
+			return
+		}
+		id, _ := strconv.Atoi(match[1])
+		scan := certmon.DB.GetScanByStateID(id)
+		if scan == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(scan)
+	} else {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func onStateCerts(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		expire := getSingleQueryParam(r, "expire")
@@ -144,6 +424,15 @@ func onStateCerts(w http.ResponseWriter, r *http.Request) {
 			replyBadRequest(w, r)
 			return
 		}
+		if severity := getSingleQueryParam(r, "severity"); len(severity) != 0 {
+			if !monitor.ValidSeverity(severity) {
+				replyBadRequest(w, r)
+				return
+			}
+			states := certmon.DB.GetStateCertsBySeverity(severity)
+			json.NewEncoder(w).Encode(states)
+			return
+		}
 		if value, err := strconv.Atoi(expire); err != nil {
 			states := certmon.DB.GetStateCertsBy("")
 			json.NewEncoder(w).Encode(states)
@@ -156,6 +445,62 @@ func onStateCerts(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func onSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		domain := getSingleQueryParam(r, "domain")
+		if !validateParamDomain.MatchString(domain) {
+			replyBadRequest(w, r)
+			return
+		}
+		matches := certmon.DB.SearchDomain(domain)
+		json.NewEncoder(w).Encode(matches)
+	} else {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func onBlast(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		fingerprint := getSingleQueryParam(r, "fingerprint")
+		spki := getSingleQueryParam(r, "spki")
+		if len(fingerprint)+len(spki) == 0 ||
+			(len(fingerprint) != 0 && !validateParamHashes.MatchString(fingerprint)) ||
+			(len(spki) != 0 && !validateParamHashes.MatchString(spki)) {
+			replyBadRequest(w, r)
+			return
+		}
+		radius := certmon.BlastRadius(strings.ToLower(fingerprint), strings.ToLower(spki))
+		if radius == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(radius)
+	} else {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func onCAGraph(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(certmon.CAHierarchy())
+	} else {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func onSimulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		date, err := time.Parse("2006-01-02", getSingleQueryParam(r, "date"))
+		if err != nil {
+			replyBadRequest(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(certmon.Simulate(date))
+	} else {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func replyBadRequest(w http.ResponseWriter, r *http.Request) {
 	log.Println("Invalid request or request's parameters ", r.URL.Query())
 	w.WriteHeader(http.StatusBadRequest)
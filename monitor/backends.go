@@ -0,0 +1,103 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// lookupIPAddr resolves A/AAAA records of the name
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// resolveHost returns addresses of all A/AAAA records of the host with the same port
+func (mon Monitor) resolveHost(host string) ([]string, error) {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, &ProbeError{Category: ErrorDNS, Err: err}
+	}
+	if net.ParseIP(name) != nil {
+		return []string{host}, nil
+	}
+
+	timeout := time.Duration(mon.Cfg.TLSTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ips, err := lookupIPAddr(ctx, name)
+	if err != nil {
+		return nil, &ProbeError{Category: ErrorDNS, Err: err}
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	return addrs, nil
+}
+
+// errorCategory returns the category of the probe failure
+func errorCategory(err error) string {
+	var probeErr *ProbeError
+	if errors.As(err, &probeErr) {
+		return probeErr.Category
+	}
+	return ErrorNetwork
+}
+
+// probeBackends probes every address of the state host with the same SNI.
+// It returns the first successful probe and flags the state if the backends are inconsistent
+func (mon Monitor) probeBackends(st *DBStateRow) (*TLSProbe, error) {
+	var (
+		result    *TLSProbe
+		resultErr error
+	)
+
+	st.Backends = make([]DBBackendRow, 0, 1)
+	st.Inconsistent = false
+	addrs, err := mon.resolveHost(st.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	withHTTP := mon.httpEnabled(*st)
+	fingerprints := make(map[string]bool)
+	failed := make([]DBBackendRow, 0, 1)
+	for _, addr := range addrs {
+		backend := DBBackendRow{Address: addr}
+		// HTTP is requested until the first successful probe which is the result
+		probe, err := mon.probe(addr, st.SNI, st.Protocol, withHTTP && result == nil)
+		if err == nil && len(probe.PeerCertificates) == 0 {
+			err = &ProbeError{Category: ErrorProtocol, Err: fmt.Errorf("No certificates from %s", addr)}
+		}
+		if err != nil {
+			log.Println(err)
+			backend.Error = err.Error()
+			backend.ErrorCategory = errorCategory(err)
+			failed = append(failed, backend)
+			if resultErr == nil {
+				resultErr = err
+			}
+		} else {
+			backend.Fingerprint = fingerprint(probe.PeerCertificates[0].Raw)
+			fingerprints[backend.Fingerprint] = true
+			if result == nil {
+				result = probe
+			}
+		}
+		st.Backends = append(st.Backends, backend)
+	}
+	if result == nil {
+		return nil, resultErr
+	}
+
+	if len(fingerprints) > 1 {
+		st.Inconsistent = true
+		st.Description = st.Description + fmt.Sprintf("\nBackends serve %d different certificates", len(fingerprints))
+	}
+	for _, backend := range failed {
+		st.Inconsistent = true
+		st.Description = st.Description + fmt.Sprintf("\nBackend %s failed: %s", backend.Address, backend.Error)
+	}
+	return result, nil
+}
@@ -0,0 +1,94 @@
+package monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProbeBackends(t *testing.T) {
+	leaf, leafKey := testCert(t, "backend.example.com", false, nil, nil, time.Now().Add(time.Hour))
+	other, otherKey := testCert(t, "backend.example.com", false, nil, nil, time.Now().Add(time.Hour))
+	certs := map[string]tls.Certificate{
+		"leaf":  {Certificate: [][]byte{leaf.Raw}, PrivateKey: leafKey},
+		"other": {Certificate: [][]byte{other.Raw}, PrivateKey: otherKey},
+	}
+
+	tests := []struct {
+		name             string
+		backends         []string
+		wantErr          bool
+		wantInconsistent bool
+		wantFailed       int
+	}{
+		{"single", []string{"leaf"}, false, false, 0},
+		{"same certificate", []string{"leaf", "leaf"}, false, false, 0},
+		{"different certificates", []string{"leaf", "other"}, false, true, 0},
+		{"failed backend", []string{"leaf", ""}, false, true, 1},
+		{"first backend failed", []string{"", "leaf"}, false, true, 1},
+		{"all failed", []string{"", ""}, true, false, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// backends listen on the same port of the loopback addresses, empty one is closed
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, port, _ := net.SplitHostPort(l.Addr().String())
+			ips := make([]net.IPAddr, 0, len(tt.backends))
+			for i, name := range tt.backends {
+				ip := net.IPv4(127, 0, 0, byte(i+1))
+				ips = append(ips, net.IPAddr{IP: ip})
+				if i > 0 {
+					l, err = net.Listen("tcp", net.JoinHostPort(ip.String(), port))
+					if err != nil {
+						t.Skip(err)
+					}
+				}
+				if len(name) == 0 {
+					l.Close()
+					continue
+				}
+				srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+				srv.Listener = l
+				srv.TLS = &tls.Config{Certificates: []tls.Certificate{certs[name]}}
+				srv.StartTLS()
+				defer srv.Close()
+			}
+			defer func(lookup func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = lookup }(lookupIPAddr)
+			lookupIPAddr = func(context.Context, string) ([]net.IPAddr, error) { return ips, nil }
+
+			mon := testMonitor(t)
+			st := NewState("backend.example.com:"+port, "backend.example.com", "")
+			st.Protocol = ProtoTLS
+			probe, err := mon.probeBackends(st)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("probeBackends() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(probe.PeerCertificates) == 0 {
+				t.Fatal("no certificates of the successful backend")
+			}
+			if len(st.Backends) != len(tt.backends) {
+				t.Fatalf("%d backends, want %d", len(st.Backends), len(tt.backends))
+			}
+			failed := 0
+			for i, backend := range st.Backends {
+				if len(backend.Error) != 0 {
+					failed++
+					continue
+				}
+				if want := fingerprint(certs[tt.backends[i]].Certificate[0]); backend.Fingerprint != want {
+					t.Errorf("backend %s fingerprint %s, want %s", backend.Address, backend.Fingerprint, want)
+				}
+			}
+			if st.Inconsistent != tt.wantInconsistent || failed != tt.wantFailed {
+				t.Errorf("inconsistent %v failed %d, want %v %d", st.Inconsistent, failed, tt.wantInconsistent, tt.wantFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+)
+
+// BlastState represents the state which serves the certificate
+//	Fingerprint - the served certificate with the key
+type BlastState struct {
+	DBStateRow
+	Fingerprint string `json:"fingerprint"`
+}
+
+// BlastZone represents states of the zone, the name is empty for states out of the zones
+type BlastZone struct {
+	Name   string       `json:"name"`
+	States []BlastState `json:"states"`
+}
+
+// BlastRadius represents all states which must be redeployed
+// when the certificate is revoked or its key is compromised
+//	Certificates - certificates with the same public key
+//	KeyReuse - the public key is shared by several certificates
+type BlastRadius struct {
+	Certificates []DBCertRow `json:"certificates"`
+	Description  string      `json:"description"`
+	KeyReuse     bool        `json:"keyReuse"`
+	SPKIHash     string      `json:"spkiHash"`
+	States       int         `json:"states"`
+	Zones        []BlastZone `json:"zones"`
+}
+
+// BlastRadius returns states serving certificates with the key of the certificate found
+// by the fingerprint or by the SPKI hash, nil if there is no such certificate
+func (mon Monitor) BlastRadius(fp string, spki string) *BlastRadius {
+	if len(fp) != 0 {
+		certs := mon.DB.GetCertificatesBy(fmt.Sprintf("WHERE fingerprint='%s'", escapeSQL(fp)))
+		if len(certs) == 0 {
+			return nil
+		}
+		spki = certs[0].SPKIHash
+	}
+	certs := mon.DB.GetCertificatesBy(fmt.Sprintf("WHERE spki_hash='%s'", escapeSQL(spki)))
+	if len(spki) == 0 || len(certs) == 0 {
+		return nil
+	}
+	mon.CertSeverity(certs)
+
+	radius := &BlastRadius{
+		Certificates: certs,
+		KeyReuse:     len(certs) > 1,
+		SPKIHash:     spki,
+		Zones:        make([]BlastZone, 0, 1),
+	}
+	if radius.KeyReuse {
+		radius.Description = fmt.Sprintf("Public key is reused by %d certificates", len(certs))
+	}
+	zones := make(map[string][]BlastState)
+	for _, cert := range certs {
+		for _, st := range mon.DB.GetStatesByCertificate(cert.Fingerprint) {
+			name := ""
+			if zone := mon.Cfg.findZone(st.SNI); zone != nil {
+				name = zone.Name
+			}
+			zones[name] = append(zones[name], BlastState{DBStateRow: st, Fingerprint: cert.Fingerprint})
+			radius.States++
+		}
+	}
+	for name, states := range zones {
+		radius.Zones = append(radius.Zones, BlastZone{Name: name, States: states})
+	}
+	sort.Slice(radius.Zones, func(i, j int) bool {
+		return radius.Zones[i].Name < radius.Zones[j].Name
+	})
+	return radius
+}
@@ -0,0 +1,114 @@
+package monitor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestBlastRadius(t *testing.T) {
+	year := time.Now().AddDate(1, 0, 0)
+	root, rootKey := testCert(t, "Test Root", true, nil, nil, year)
+	shared, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	// issue returns the leaf certificate with the shared key
+	issue := func(serial int64, name string) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     year,
+			DNSNames:     []string{name},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, root, &shared.PublicKey, rootKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, _ := x509.ParseCertificate(der)
+		return cert
+	}
+	first, second := issue(1, "www.example.com"), issue(2, "www.example.org")
+	other, _ := testCert(t, "other.example.com", false, root, rootKey, year)
+
+	mon := testMonitor(t)
+	mon.Cfg.Zones = []ZoneConfig{{Name: "example.com."}, {Name: "dev.example.com."}}
+	served := []struct {
+		sni  string
+		cert *x509.Certificate
+	}{
+		{"www.example.com", first},
+		{"example.com", first},
+		{"api.dev.example.com", second},
+		{"www.example.org", second},
+		{"other.example.com", other},
+	}
+	for _, s := range served {
+		row := newCertRow(s.cert)
+		if err := mon.DB.InsertCert(row); err != nil {
+			t.Fatal(err)
+		}
+		st := NewState(s.sni+":443", s.sni, "")
+		if err := mon.DB.InsertState(*st); err != nil {
+			t.Fatal(err)
+		}
+		st.Valid = ValidState
+		st.Certificates = []DBCertRow{row}
+		if err := mon.DB.UpdateState(st); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		fp       string
+		spki     string
+		keyReuse bool
+		zones    []string
+		states   []int
+	}{
+		{"by fingerprint", fingerprint(first.Raw), "", true,
+			[]string{"", "dev.example.com.", "example.com."}, []int{1, 1, 2}},
+		{"by SPKI hash", "", spkiHash(second), true,
+			[]string{"", "dev.example.com.", "example.com."}, []int{1, 1, 2}},
+		{"single certificate", fingerprint(other.Raw), "", false,
+			[]string{"example.com."}, []int{1}},
+		{"unknown fingerprint", "00", "", false, nil, nil},
+		{"unknown SPKI hash", "", "00", false, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			radius := mon.BlastRadius(tt.fp, tt.spki)
+			if tt.zones == nil {
+				if radius != nil {
+					t.Fatalf("BlastRadius() = %+v, want nil", radius)
+				}
+				return
+			}
+			if radius == nil {
+				t.Fatal("BlastRadius() = nil")
+			}
+			if radius.KeyReuse != tt.keyReuse || len(radius.Zones) != len(tt.zones) {
+				t.Fatalf("key reuse %v zones %d, want %v %d", radius.KeyReuse, len(radius.Zones), tt.keyReuse, len(tt.zones))
+			}
+			total := 0
+			for i, zone := range radius.Zones {
+				if zone.Name != tt.zones[i] || len(zone.States) != tt.states[i] {
+					t.Errorf("zone %q with %d states, want %q with %d", zone.Name, len(zone.States), tt.zones[i], tt.states[i])
+				}
+				for _, st := range zone.States {
+					if len(st.Fingerprint) == 0 {
+						t.Errorf("state %s has no fingerprint", st.Host)
+					}
+				}
+				total += tt.states[i]
+			}
+			if radius.States != total {
+				t.Errorf("%d states, want %d", radius.States, total)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// FindingCAA is the rule name of CAA compliance findings
+	FindingCAA = "caa"
+
+	caaTagIssue     = "issue"
+	caaTagIssueWild = "issuewild"
+)
+
+// lookupCAA returns CAA records of the name, empty if there are no records
+func (mon Monitor) lookupCAA(name string) ([]*dns.CAA, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), dns.TypeCAA)
+	msg.SetEdns0(4096, false)
+
+	reply, err := exchange(msg, mon.Cfg.resolver(name), time.Duration(mon.Cfg.TLSTimeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Rcode != dns.RcodeSuccess && reply.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("CAA lookup of %s is failed: %s", name, dns.RcodeToString[reply.Rcode])
+	}
+	records := make([]*dns.CAA, 0, len(reply.Answer))
+	for _, rr := range reply.Answer {
+		if caa, ok := rr.(*dns.CAA); ok {
+			records = append(records, caa)
+		}
+	}
+	return records, nil
+}
+
+// relevantCAA climbs the tree from the name to the root and returns the first non-empty CAA record set
+// and the name which it belongs to
+func (mon Monitor) relevantCAA(name string) ([]*dns.CAA, string, error) {
+	name = normalizeDomain(name)
+	for len(name) != 0 {
+		records, err := mon.lookupCAA(name)
+		if err != nil || len(records) != 0 {
+			return records, name, err
+		}
+		name = parentDomain(name)
+	}
+	return nil, "", nil
+}
+
+// caaIssuers returns issuer domains allowed by the records, the issuewild properties take precedence
+// for wildcard certificates, the empty list forbids issuance, it is false if the records do not restrict issuance
+func caaIssuers(records []*dns.CAA, wildcard bool) ([]string, bool) {
+	tag := caaTagIssue
+	if wildcard {
+		for _, record := range records {
+			if strings.EqualFold(record.Tag, caaTagIssueWild) {
+				tag = caaTagIssueWild
+				break
+			}
+		}
+	}
+	issuers := make([]string, 0, len(records))
+	restricted := false
+	for _, record := range records {
+		if !strings.EqualFold(record.Tag, tag) {
+			continue
+		}
+		restricted = true
+		domain := strings.TrimSpace(strings.SplitN(record.Value, ";", 2)[0])
+		if len(domain) != 0 {
+			issuers = append(issuers, strings.ToLower(domain))
+		}
+	}
+	return issuers, restricted
+}
+
+// caaDomains returns CAA domains of the leaf issuer from `caa` configuration section,
+// the issuer is matched by the subject hash first and then by the organization in the sorted order
+func (cfg *Config) caaDomains(leaf *x509.Certificate) []string {
+	issuers := make([]string, 0, len(cfg.CAA))
+	for issuer := range cfg.CAA {
+		issuers = append(issuers, issuer)
+	}
+	sort.Strings(issuers)
+
+	issuerHash := fingerprint(leaf.RawIssuer)
+	for _, issuer := range issuers {
+		if strings.EqualFold(issuer, issuerHash) {
+			return cfg.CAA[issuer]
+		}
+	}
+	for _, issuer := range issuers {
+		if containsFold(leaf.Issuer.Organization, issuer) {
+			return cfg.CAA[issuer]
+		}
+	}
+	return nil
+}
+
+// coveredByWildcard reports whether the name is matched by the wildcard name of the leaf only
+func coveredByWildcard(leaf *x509.Certificate, name string) bool {
+	name = normalizeDomain(name)
+	for _, san := range leaf.DNSNames {
+		if normalizeDomain(san) == name {
+			return false
+		}
+	}
+	return leaf.VerifyHostname(name) == nil
+}
+
+// checkCAA compares the issuer of the served leaf against CAA records of the state name
+func (mon Monitor) checkCAA(st *DBStateRow, certs []*x509.Certificate) {
+	if len(mon.Cfg.CAA) == 0 || len(certs) == 0 || len(st.SNI) == 0 || net.ParseIP(st.SNI) != nil {
+		return
+	}
+	finding := func(severity string, format string, args ...interface{}) {
+		st.Findings = append(st.Findings, DBStateFindingRow{
+			Message:  fmt.Sprintf(format, args...),
+			Rule:     FindingCAA,
+			Severity: severity,
+			StateID:  st.ID,
+		})
+	}
+
+	records, owner, err := mon.relevantCAA(st.SNI)
+	if err != nil {
+		finding(LintWarning, "%s", err.Error())
+		return
+	}
+	if len(records) == 0 {
+		return
+	}
+	issuer := certName(&x509.Certificate{Subject: certs[0].Issuer})
+	domains := mon.Cfg.caaDomains(certs[0])
+	if len(domains) == 0 {
+		// CAA restricts public CAs only, private issuers are checked if they are mapped
+		if classifyCert(certs[0], certs, time.Now()) == CertPublicCA {
+			finding(LintNotice, "Issuer %s is not mapped to CAA domains", issuer)
+		}
+		return
+	}
+	allowed, restricted := caaIssuers(records, coveredByWildcard(certs[0], st.SNI))
+	if !restricted {
+		return
+	}
+	for _, domain := range domains {
+		if containsFold(allowed, domain) {
+			return
+		}
+	}
+	if len(allowed) == 0 {
+		finding(LintError, "CAA records of %s forbid issuance, the certificate is issued by %s", owner, issuer)
+		return
+	}
+	finding(LintError, "CAA records of %s allow %s only, the certificate is issued by %s",
+		owner, strings.Join(allowed, ", "), issuer)
+}
@@ -0,0 +1,72 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCAAIssuers(t *testing.T) {
+	caa := func(tag string, value string) *dns.CAA {
+		return &dns.CAA{Tag: tag, Value: value}
+	}
+	tests := []struct {
+		name       string
+		records    []*dns.CAA
+		wildcard   bool
+		issuers    []string
+		restricted bool
+	}{
+		{"no records", nil, false, []string{}, false},
+		{"iodef only", []*dns.CAA{caa("iodef", "mailto:ca@example.com")}, false, []string{}, false},
+		{"issue", []*dns.CAA{caa("issue", "Good.CA"), caa("issue", "other.ca; account=1")}, false,
+			[]string{"good.ca", "other.ca"}, true},
+		{"forbidden", []*dns.CAA{caa("issue", ";")}, false, []string{}, true},
+		{"wildcard falls back to issue", []*dns.CAA{caa("issue", "good.ca")}, true, []string{"good.ca"}, true},
+		{"issuewild takes precedence", []*dns.CAA{caa("issue", "good.ca"), caa("issuewild", "wild.ca")}, true,
+			[]string{"wild.ca"}, true},
+		{"issuewild is ignored for names", []*dns.CAA{caa("issue", "good.ca"), caa("ISSUEWILD", "wild.ca")}, false,
+			[]string{"good.ca"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuers, restricted := caaIssuers(tt.records, tt.wildcard)
+			if !reflect.DeepEqual(issuers, tt.issuers) || restricted != tt.restricted {
+				t.Errorf("caaIssuers() = %v %v, want %v %v", issuers, restricted, tt.issuers, tt.restricted)
+			}
+		})
+	}
+}
+
+func TestCAADomains(t *testing.T) {
+	leaf := &x509.Certificate{
+		Issuer:    pkix.Name{CommonName: "Test CA", Organization: []string{"Test Org", "Other Org"}},
+		RawIssuer: []byte("issuer"),
+	}
+	hash := fingerprint(leaf.RawIssuer)
+	tests := []struct {
+		name string
+		caa  map[string][]string
+		want []string
+	}{
+		{"not mapped", map[string][]string{"Unknown": {"unknown.ca"}}, nil},
+		{"organization", map[string][]string{"test org": {"org.ca"}}, []string{"org.ca"}},
+		{"hash before organization", map[string][]string{"Test Org": {"org.ca"}, hash: {"hash.ca"}},
+			[]string{"hash.ca"}},
+		{"sorted organizations", map[string][]string{"Test Org": {"test.ca"}, "Other Org": {"other.ca"}},
+			[]string{"other.ca"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{CAA: tt.caa}
+			for i := 0; i < 10; i++ {
+				if got := cfg.caaDomains(leaf); !reflect.DeepEqual(got, tt.want) {
+					t.Fatalf("caaDomains() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
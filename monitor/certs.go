@@ -1,6 +1,7 @@
 package monitor
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"crypto/tls"
 	"crypto/x509"
@@ -8,13 +9,36 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strings"
 	"time"
 )
 
-// CheckCertificate checks certificate chain for the host
+const (
+	// ChainOK means that the chain is built to a trusted root
+	ChainOK = "ok"
+	// ChainUntrustedRoot means that the chain ends with an unknown root,
+	//	self-signed certificates are also here
+	ChainUntrustedRoot = "untrusted_root"
+	// ChainMissingIntermediate means that the host does not send an intermediate certificate
+	ChainMissingIntermediate = "missing_intermediate"
+	// ChainWrongOrder means that the certificates are sent not in the issuing order
+	ChainWrongOrder = "wrong_order"
+	// ChainExtraCerts means that the host sends certificates which are not in the chain
+	ChainExtraCerts = "extra_certs"
+	// ChainInvalid means that the chain is not valid for another reason
+	ChainInvalid = "invalid"
+)
+
+// CheckCertificate checks certificate chain for the host,
+// IP literal hostname is checked against IP SANs
 func CheckCertificate(cert *x509.Certificate, hostname string) error {
+	return checkCertificateAt(cert, hostname, time.Now())
+}
 
-	if time.Now().After(cert.NotAfter) || time.Now().Before(cert.NotBefore) {
+// checkCertificateAt checks the certificate as it is the moment now
+func checkCertificateAt(cert *x509.Certificate, hostname string, now time.Time) error {
+
+	if now.After(cert.NotAfter) || now.Before(cert.NotBefore) {
 		msg := fmt.Sprintf("Certificate %s is expired or inactived yet", cert.Subject.CommonName)
 		return errors.New(msg)
 	}
@@ -24,37 +48,233 @@ func CheckCertificate(cert *x509.Certificate, hostname string) error {
 	return cert.VerifyHostname(hostname)
 }
 
+// CheckChain builds the path from the served certificates to the trusted roots.
+// It returns the found chain problems (see `Chain*`) and the error describing them.
+// The roots are the system ones if the pool is nil
+func CheckChain(certs []*x509.Certificate, roots *x509.CertPool, now time.Time) ([]string, error) {
+	if len(certs) == 0 {
+		return []string{ChainInvalid}, errors.New("No certificates")
+	}
+
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		if _, ok := err.(x509.UnknownAuthorityError); !ok {
+			return []string{ChainInvalid}, err
+		}
+		last := servedPath(certs)
+		if isSelfSigned(last) {
+			msg := fmt.Sprintf("Chain is issued by untrusted root %s", certName(last))
+			return []string{ChainUntrustedRoot}, errors.New(msg)
+		}
+		// the served intermediate is issued by a root which is not in the trust store
+		if last != leaf && last.IsCA {
+			msg := fmt.Sprintf("Chain is issued by untrusted root %s", last.Issuer.CommonName)
+			return []string{ChainUntrustedRoot}, errors.New(msg)
+		}
+		msg := fmt.Sprintf("Chain misses intermediate certificate %s", last.Issuer.CommonName)
+		return []string{ChainMissingIntermediate}, errors.New(msg)
+	}
+
+	used := make(map[string]bool)
+	for _, chain := range chains {
+		for _, cert := range chain {
+			used[fingerprint(cert.Raw)] = true
+		}
+	}
+	problems := make([]string, 0, 1)
+	msgs := make([]string, 0, 1)
+	extra := make([]string, 0, 1)
+	sent := make([]*x509.Certificate, 0, len(certs))
+	for _, cert := range certs {
+		if used[fingerprint(cert.Raw)] {
+			sent = append(sent, cert)
+		} else {
+			extra = append(extra, certName(cert))
+		}
+	}
+	for i := 1; i < len(sent); i++ {
+		if !bytes.Equal(sent[i-1].RawIssuer, sent[i].RawSubject) {
+			problems = append(problems, ChainWrongOrder)
+			msgs = append(msgs, "Chain certificates are sent in wrong order")
+			break
+		}
+	}
+	if len(extra) != 0 {
+		problems = append(problems, ChainExtraCerts)
+		msgs = append(msgs, fmt.Sprintf("Chain contains extra certificates %s", strings.Join(extra, ", ")))
+	}
+	if len(problems) == 0 {
+		return []string{ChainOK}, nil
+	}
+	return problems, errors.New(strings.Join(msgs, "\n"))
+}
+
+// servedPath follows the issuers from the leaf through the served certificates
+// and returns the last found one
+func servedPath(certs []*x509.Certificate) *x509.Certificate {
+	last := certs[0]
+	seen := map[*x509.Certificate]bool{last: true}
+	for !isSelfSigned(last) {
+		var issuer *x509.Certificate
+		for _, cert := range certs {
+			if !seen[cert] && bytes.Equal(last.RawIssuer, cert.RawSubject) {
+				issuer = cert
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		seen[issuer] = true
+		last = issuer
+	}
+	return last
+}
+
+// certName returns the common name or the whole subject if it is empty
+func certName(cert *x509.Certificate) string {
+	if len(cert.Subject.CommonName) != 0 {
+		return cert.Subject.CommonName
+	}
+	return cert.Subject.String()
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return false
+	}
+	return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
 func fingerprint(data []byte) string {
 	return fmt.Sprintf("%x", sha1.Sum(data))
 }
 
-// GetCertificates returns the full certificate chain from TLS connection
-func (mon Monitor) GetCertificates(host string, sni string) []*x509.Certificate {
+// TLSProbe represents the result of TLS connection to the host
+//	Latency - duration of TLS handshake
+//	HTTP - response to HTTP request over the connection, nil if it is not requested
+type TLSProbe struct {
+	tls.ConnectionState
+	HTTP    *HTTPProbe
+	Latency time.Duration
+}
+
+// dialPlain establishes TCP connection to the host and negotiates TLS
+// according to the protocol, so the connection is ready to handshake
+func (mon Monitor) dialPlain(host string, proto string, sni string) (net.Conn, error) {
 
 	timeout := time.Duration(mon.Cfg.TLSTimeout) * time.Second
 	tcpConn, err := net.DialTimeout("tcp", host, timeout)
 	if err != nil {
-		log.Printf("Failed to establish TCP connection to %s: %s\n", host, err)
-		return nil
+		return nil, dialError(err, fmt.Sprintf("Failed to establish TCP connection to %s", host))
 	}
-	defer tcpConn.Close()
+	tcpConn.SetDeadline(time.Now().Add(timeout))
 
-	tlsConn := tls.Client(tcpConn, &tls.Config{
+	if err := startTLS(tcpConn, proto, sni); err != nil {
+		tcpConn.Close()
+		return nil, negotiationError(err, fmt.Sprintf("Failed to negotiate %s with %s", proto, host))
+	}
+	return tcpConn, nil
+}
+
+// dialTLS establishes connection to the host and makes handshake.
+// It returns the connection and the handshake duration
+func (mon Monitor) dialTLS(host string, proto string, cfg *tls.Config) (*tls.Conn, time.Duration, error) {
+
+	tcpConn, err := mon.dialPlain(host, proto, cfg.ServerName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	tlsConn := tls.Client(tcpConn, cfg)
+	started := time.Now()
+	if err := tlsConn.Handshake(); err != nil {
+		tlsConn.Close()
+		return nil, 0, handshakeError(err, fmt.Sprintf("Failed to handshake with %s", host))
+	}
+
+	return tlsConn, time.Since(started), nil
+}
+
+// Probe connects to the host and returns the negotiated TLS parameters
+func (mon Monitor) Probe(host string, sni string, proto string) (*TLSProbe, error) {
+	return mon.probe(host, sni, proto, false)
+}
+
+// probe connects to the host and returns the negotiated TLS parameters,
+// HTTPS hosts are also requested over the connection if it is asked
+func (mon Monitor) probe(host string, sni string, proto string, withHTTP bool) (*TLSProbe, error) {
+
+	// legacy versions and cipher suites are negotiated to be recorded and flagged as weak
+	cfg := &tls.Config{
 		InsecureSkipVerify: true,
 		ServerName:         sni,
-	})
-	if tlsConn == nil {
-		log.Printf("Can not create TLS client for %s: %s\n", host, err)
-		return nil
+		MinVersion:         tls.VersionTLS10,
+		CipherSuites:       probeCipherSuites(),
+	}
+	if proto == ProtoTLS {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+	tlsConn, latency, err := mon.dialTLS(host, proto, cfg)
+	delay := time.Duration(mon.Cfg.RetryDelay) * time.Millisecond
+	for attempt := 0; err != nil && attempt < mon.Cfg.Retries && retriable(err); attempt++ {
+		log.Printf("Retry %s in %s: %s\n", host, delay, err)
+		time.Sleep(delay)
+		delay = delay * 2
+		tlsConn, latency, err = mon.dialTLS(host, proto, cfg)
+	}
+	if err != nil {
+		return nil, err
 	}
 	defer tlsConn.Close()
 
-	tlsConn.SetDeadline(time.Now().Add(timeout))
-	if err := tlsConn.Handshake(); err != nil {
-		log.Printf("Failed to handshake with %s: %s\n", host, err)
+	result := &TLSProbe{
+		ConnectionState: tlsConn.ConnectionState(),
+		Latency:         latency,
+	}
+	if withHTTP && proto == ProtoTLS && httpService(host, result.NegotiatedProtocol) {
+		result.HTTP = mon.probeHTTP(tlsConn, sni)
+	}
+	return result, nil
+}
+
+// probeCipherSuites returns all cipher suites implemented by crypto/tls including the insecure ones
+func probeCipherSuites() []uint16 {
+	suites := make([]uint16, 0, 32)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites = append(suites, suite.ID)
+	}
+	return suites
+}
+
+// GetCertificates returns the full certificate chain from TLS connection
+func (mon Monitor) GetCertificates(host string, sni string, proto string) []*x509.Certificate {
+	probe, err := mon.Probe(host, sni, proto)
+	if err != nil {
+		log.Println(err)
 		return nil
 	}
-	state := tlsConn.ConnectionState()
+	return probe.PeerCertificates
+}
 
-	return state.PeerCertificates
+// isWeakTLS reports whether the protocol version is older than TLS 1.2 or the cipher suite is not AEAD
+func isWeakTLS(version uint16, suite uint16) bool {
+	if version < tls.VersionTLS12 {
+		return true
+	}
+	if version >= tls.VersionTLS13 {
+		return false
+	}
+	name := tls.CipherSuiteName(suite)
+	return !strings.Contains(name, "_GCM_") && !strings.Contains(name, "_CHACHA20_POLY1305")
 }
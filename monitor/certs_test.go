@@ -0,0 +1,151 @@
+package monitor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert issues the certificate by the parent, it is self-signed if the parent is nil,
+// the options modify the template
+func testCert(t *testing.T, name string, ca bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+	notAfter time.Time, options ...func(*x509.Certificate)) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ca {
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	} else {
+		template.DNSNames = []string{name}
+	}
+	for _, option := range options {
+		option(template)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// testChain returns the root, the intermediate and the leaf certificates
+func testChain(t *testing.T) (root *x509.Certificate, inter *x509.Certificate, leaf *x509.Certificate) {
+	year := time.Now().AddDate(1, 0, 0)
+	root, rootKey := testCert(t, "Test Root", true, nil, nil, year)
+	inter, interKey := testCert(t, "Test Intermediate", true, root, rootKey, year)
+	leaf, _ = testCert(t, "leaf.example.com", false, inter, interKey, year)
+	return
+}
+
+func TestCheckChain(t *testing.T) {
+	root, inter, leaf := testChain(t)
+	other, _ := testCert(t, "Other", true, nil, nil, time.Now().AddDate(1, 0, 0))
+	trusted := x509.NewCertPool()
+	trusted.AddCert(root)
+	untrusted := x509.NewCertPool()
+	untrusted.AddCert(other)
+
+	tests := []struct {
+		name  string
+		certs []*x509.Certificate
+		roots *x509.CertPool
+		now   time.Time
+		want  []string
+	}{
+		{"ok", []*x509.Certificate{leaf, inter}, trusted, time.Now(), []string{ChainOK}},
+		{"root is served", []*x509.Certificate{leaf, inter, root}, trusted, time.Now(), []string{ChainOK}},
+		{"wrong order", []*x509.Certificate{leaf, root, inter}, trusted, time.Now(), []string{ChainWrongOrder}},
+		{"extra certificates", []*x509.Certificate{leaf, inter, other}, trusted, time.Now(),
+			[]string{ChainExtraCerts}},
+		{"missing intermediate", []*x509.Certificate{leaf}, trusted, time.Now(),
+			[]string{ChainMissingIntermediate}},
+		{"untrusted root is served", []*x509.Certificate{leaf, inter, root}, untrusted, time.Now(),
+			[]string{ChainUntrustedRoot}},
+		{"untrusted root is not served", []*x509.Certificate{leaf, inter}, untrusted, time.Now(),
+			[]string{ChainUntrustedRoot}},
+		{"self-signed", []*x509.Certificate{root}, untrusted, time.Now(), []string{ChainUntrustedRoot}},
+		{"expired", []*x509.Certificate{leaf, inter}, trusted, time.Now().AddDate(2, 0, 0),
+			[]string{ChainInvalid}},
+		{"no certificates", nil, trusted, time.Now(), []string{ChainInvalid}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := CheckChain(tt.certs, tt.roots, tt.now)
+			if !reflect.DeepEqual(problems, tt.want) {
+				t.Errorf("CheckChain() = %v, want %v", problems, tt.want)
+			}
+			if (err == nil) != reflect.DeepEqual(tt.want, []string{ChainOK}) {
+				t.Errorf("CheckChain() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestProbeWeakTLS(t *testing.T) {
+	mon := testMonitor(t)
+	tests := []struct {
+		name    string
+		version uint16
+		suite   uint16
+		weak    bool
+	}{
+		{"TLS 1.3", tls.VersionTLS13, 0, false},
+		{"TLS 1.2 AEAD", tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, false},
+		{"TLS 1.2 ChaCha20", tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, false},
+		{"TLS 1.2 CBC", tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, true},
+		{"TLS 1.2 3DES", tls.VersionTLS12, tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA, true},
+		{"TLS 1.1", tls.VersionTLS11, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, true},
+		{"TLS 1.0", tls.VersionTLS10, tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+			srv.TLS = &tls.Config{MinVersion: tt.version, MaxVersion: tt.version}
+			if tt.suite != 0 {
+				srv.TLS.CipherSuites = []uint16{tt.suite}
+			}
+			srv.StartTLS()
+			defer srv.Close()
+
+			probe, err := mon.Probe(strings.TrimPrefix(srv.URL, "https://"), "example.com", ProtoTLS)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if probe.Version != tt.version || (tt.suite != 0 && probe.CipherSuite != tt.suite) {
+				t.Fatalf("negotiated %s %s", tls.VersionName(probe.Version), tls.CipherSuiteName(probe.CipherSuite))
+			}
+			if weak := isWeakTLS(probe.Version, probe.CipherSuite); weak != tt.weak {
+				t.Errorf("isWeakTLS() = %v, want %v", weak, tt.weak)
+			}
+		})
+	}
+}
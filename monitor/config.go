@@ -1,22 +1,106 @@
 package monitor
 
 import (
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
+	"time"
 )
 
 // ZoneConfig represents item at `zone` configuration section
 // 	Master - master DNS server
 //	Name - zone name
 //	Proto - protocol (tcp/udp)
+//	OmitMX - do not discover MX hosts
+//	PortMX - port of discovered MX hosts
+//	ProtoMX - TLS negotiation protocol of MX hosts (tls/smtp/imap/...),
+//		by default it is chosen by `PortMX`
+//	TrustBundle - PEM file with roots trusted for hosts of the zone
+//	CRLDir - directory with CRL files for air-gapped zones
+//	WarningDays, CriticalDays - override the global expiration thresholds
+//	Pin - expected certificates of the zone hosts, see `Pin`
+//	DANE - TLSA records must be published for all hosts of the zone
+//	DANEMX - TLSA records must be published for SMTP hosts of the zone
+//	HTTP - request HTTPS hosts of the zone after the handshake
 type ZoneConfig struct {
-	Master string `json:"master"`
-	Name   string `json:"name"`
-	Proto  string `json:"proto,omitempty"`
-	OmitMX bool   `json:"omitMX"`
-	PortMX int    `json:"portMX"`
+	Master       string `json:"master"`
+	Name         string `json:"name"`
+	Proto        string `json:"proto,omitempty"`
+	OmitMX       bool   `json:"omitMX"`
+	PortMX       int    `json:"portMX"`
+	ProtoMX      string `json:"protoMX,omitempty"`
+	TrustBundle  string `json:"trustBundle,omitempty"`
+	CRLDir       string `json:"crlDir,omitempty"`
+	WarningDays  int    `json:"warningDays,omitempty"`
+	CriticalDays int    `json:"criticalDays,omitempty"`
+	Pin          *Pin   `json:"pin,omitempty"`
+	DANE         bool   `json:"dane,omitempty"`
+	DANEMX       bool   `json:"daneMX,omitempty"`
+	HTTP         bool   `json:"http,omitempty"`
+}
+
+// TargetConfig represents item at `targets` configuration section,
+// it overrides settings for the specific monitored host
+//	Host - host and port of the state
+//	SNI - server name of the state, any if it is empty
+//	TrustBundle - PEM file with roots trusted for the host
+//	Scan - enumerate accepted protocol versions and cipher suites
+//	WarningDays, CriticalDays - override the zone and the global expiration thresholds
+//	Pin - expected certificates of the host, it overrides the zone pin
+//	DANE - TLSA records must be published for the host
+//	HTTP - request the host after the handshake if it is HTTPS
+type TargetConfig struct {
+	Host         string `json:"host"`
+	SNI          string `json:"sni,omitempty"`
+	TrustBundle  string `json:"trustBundle,omitempty"`
+	Scan         bool   `json:"scan,omitempty"`
+	WarningDays  int    `json:"warningDays,omitempty"`
+	CriticalDays int    `json:"criticalDays,omitempty"`
+	Pin          *Pin   `json:"pin,omitempty"`
+	DANE         bool   `json:"dane,omitempty"`
+	HTTP         bool   `json:"http,omitempty"`
+}
+
+// DistrustConfig represents item at `distrust` configuration section,
+// CA is matched by the subject hash or by the public key
+//	SubjectHash - subject hash of the CA, see `issuerHash` of the issued certificates
+//	SPKIHash - SHA-256 hash of the CA public key
+//	Since - only leaf certificates issued after the moment (RFC 3339) are distrusted, all if it is empty
+//	Description - reason of the distrust
+type DistrustConfig struct {
+	SubjectHash string `json:"subjectHash,omitempty"`
+	SPKIHash    string `json:"spkiHash,omitempty"`
+	Since       string `json:"since,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	since time.Time
+}
+
+// parseSince parses the `Since` moment of the distrust
+func (distrust *DistrustConfig) parseSince() error {
+	if len(distrust.Since) == 0 {
+		distrust.since = time.Time{}
+		return nil
+	}
+	since, err := time.Parse(time.RFC3339, distrust.Since)
+	if err != nil {
+		return fmt.Errorf("Invalid distrust since %q, RFC 3339 time is expected", distrust.Since)
+	}
+	distrust.since = since
+	return nil
+}
+
+// LintRuleConfig represents item at `lint` configuration section
+//	Disabled - do not run the rule
+//	Severity - override the rule severity (notice/warning/error)
+type LintRuleConfig struct {
+	Disabled bool   `json:"disabled,omitempty"`
+	Severity string `json:"severity,omitempty"`
 }
 
 // Config represents application configuration
@@ -27,16 +111,51 @@ type ZoneConfig struct {
 //	RetransferDelay - delay between afxr requests
 //	TLSTimeout - timeout TLS connections
 //	WatcherDelay - delay between periodic state checks
+//	ScanDelay - delay between deep scans, 0 disables them
+//	Retries - number of probe retries on transient failures
+//	RetryDelay - delay before the first retry in milliseconds, it is doubled on each retry
+//	FailThreshold - number of consecutive checks before the state validity is changed
+//	WarningDays - days before expiration when the state gets warning severity
+//	CriticalDays - days before expiration when the state gets critical severity
+//	TrustBundle - PEM file with roots trusted in addition to the system ones
+//	OCSP - query OCSP responders of the served certificates
+//	CRL - download CRLs from distribution points of the served certificates
+//	Lint - rules settings by the rule name, see `LintRules`
+//	Distrust - distrusted CAs, see `DistrustConfig`
+//	CAA - CAA domains of the issuers, the issuer is the subject hash or the organization name
+//	Resolver - validating recursive DNS server for TLSA lookups, by default the system resolver
+//	HTTP - request HTTPS hosts after the handshake: status, HSTS and redirect from port 80,
+//	the hosts on port 443 or negotiating HTTP by ALPN are requested only
+//	HSTSMinAge - the shortest acceptable HSTS max-age in seconds, one year by default
 // Zones - see `ZoneConfig`
+// Targets - see `TargetConfig`
 type Config struct {
-	WorkDir         string       `json:"workDir"`
-	Listen          string       `json:"listen"`
-	LogPrefix       string       `json:"logPrefix"`
-	MaxThreads      int          `json:"maxThreads"`
-	RetransferDelay int          `json:"retransferDelay"`
-	TLSTimeout      int          `json:"tlsTimeout"`
-	WatcherDelay    int          `json:"watcherDelay"`
-	Zones           []ZoneConfig `json:"zones"`
+	WorkDir         string                    `json:"workDir"`
+	Listen          string                    `json:"listen"`
+	LogPrefix       string                    `json:"logPrefix"`
+	MaxThreads      int                       `json:"maxThreads"`
+	RetransferDelay int                       `json:"retransferDelay"`
+	TLSTimeout      int                       `json:"tlsTimeout"`
+	WatcherDelay    int                       `json:"watcherDelay"`
+	ScanDelay       int                       `json:"scanDelay"`
+	Retries         int                       `json:"retries"`
+	RetryDelay      int                       `json:"retryDelay"`
+	FailThreshold   int                       `json:"failThreshold"`
+	WarningDays     int                       `json:"warningDays"`
+	CriticalDays    int                       `json:"criticalDays"`
+	TrustBundle     string                    `json:"trustBundle,omitempty"`
+	OCSP            bool                      `json:"ocsp"`
+	CRL             bool                      `json:"crl"`
+	Lint            map[string]LintRuleConfig `json:"lint,omitempty"`
+	Distrust        []DistrustConfig          `json:"distrust,omitempty"`
+	CAA             map[string][]string       `json:"caa,omitempty"`
+	Resolver        string                    `json:"resolver,omitempty"`
+	HTTP            bool                      `json:"http"`
+	HSTSMinAge      int                       `json:"hstsMinAge,omitempty"`
+	Zones           []ZoneConfig              `json:"zones"`
+	Targets         []TargetConfig            `json:"targets,omitempty"`
+
+	roots map[string]*x509.CertPool
 }
 
 func loadConfig(filename string) (*Config, error) {
@@ -59,5 +178,121 @@ func loadConfig(filename string) (*Config, error) {
 		log.Println("LoadConfig: ", err)
 		return nil, err
 	}
+	if cfg.WarningDays == 0 {
+		cfg.WarningDays = defaultWarningDays
+	}
+	if cfg.CriticalDays == 0 {
+		cfg.CriticalDays = defaultCriticalDays
+	}
+	for i := range cfg.Distrust {
+		distrust := &cfg.Distrust[i]
+		if len(distrust.SubjectHash) == 0 && len(distrust.SPKIHash) == 0 {
+			err := errors.New("Distrusted CA has neither subject hash nor SPKI hash")
+			log.Println("LoadConfig: ", err)
+			return nil, err
+		}
+		if err := distrust.parseSince(); err != nil {
+			log.Println("LoadConfig: ", err)
+			return nil, err
+		}
+	}
+	if err := cfg.validateLint(); err != nil {
+		log.Println("LoadConfig: ", err)
+		return nil, err
+	}
+	if err := cfg.loadTrustBundles(); err != nil {
+		log.Println("LoadConfig: ", err)
+		return nil, err
+	}
 	return cfg, nil
 }
+
+// findZone returns the zone which the name belongs to
+func (cfg *Config) findZone(name string) *ZoneConfig {
+	var zone *ZoneConfig
+
+	name = strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+	for i, z := range cfg.Zones {
+		zname := strings.ToLower(z.Name)
+		if name != zname && !strings.HasSuffix(name, "."+zname) {
+			continue
+		}
+		if zone == nil || len(zname) > len(zone.Name) {
+			zone = &cfg.Zones[i]
+		}
+	}
+	return zone
+}
+
+// findTarget returns settings of the specific host
+func (cfg *Config) findTarget(host string, sni string) *TargetConfig {
+	for i, t := range cfg.Targets {
+		if t.Host == host && (len(t.SNI) == 0 || t.SNI == sni) {
+			return &cfg.Targets[i]
+		}
+	}
+	return nil
+}
+
+// trustBundle returns PEM file with roots trusted for the host
+func (cfg *Config) trustBundle(host string, sni string) string {
+	if target := cfg.findTarget(host, sni); target != nil && len(target.TrustBundle) != 0 {
+		return target.TrustBundle
+	}
+	if zone := cfg.findZone(sni); zone != nil && len(zone.TrustBundle) != 0 {
+		return zone.TrustBundle
+	}
+	return cfg.TrustBundle
+}
+
+// Roots returns pool of roots trusted for the host,
+// nil means the system pool
+func (cfg *Config) Roots(host string, sni string) *x509.CertPool {
+	return cfg.roots[cfg.trustBundle(host, sni)]
+}
+
+func (cfg *Config) loadTrustBundles() error {
+	cfg.roots = make(map[string]*x509.CertPool)
+
+	bundles := []string{cfg.TrustBundle}
+	for _, zone := range cfg.Zones {
+		bundles = append(bundles, zone.TrustBundle)
+	}
+	for _, target := range cfg.Targets {
+		bundles = append(bundles, target.TrustBundle)
+	}
+	for _, bundle := range bundles {
+		if _, exists := cfg.roots[bundle]; len(bundle) == 0 || exists {
+			continue
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(bundle)
+		if err != nil {
+			return err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return errors.New(fmt.Sprintf("No certificates found in %s", bundle))
+		}
+		cfg.roots[bundle] = pool
+	}
+	return nil
+}
+
+func (cfg *Config) validateLint() error {
+	for name, rule := range cfg.Lint {
+		known := false
+		for _, r := range LintRules {
+			known = known || r.Name == name
+		}
+		if !known {
+			return fmt.Errorf("Unknown lint rule %s", name)
+		}
+		if len(rule.Severity) != 0 && !ValidLintSeverity(rule.Severity) {
+			return fmt.Errorf("Invalid severity %s of lint rule %s", rule.Severity, name)
+		}
+	}
+	return nil
+}
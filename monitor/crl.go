@@ -0,0 +1,228 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// CertRevocationUnknown means that revocation of the certificate is not checked
+	CertRevocationUnknown = -1
+	// CertNotRevoked means that the certificate is not listed in the issuer CRL
+	CertNotRevoked = 0
+	// CertRevoked means that the certificate is revoked
+	CertRevoked = 1
+
+	maxCRLSize = 32 * 1024 * 1024
+	// crlLifetime is the cache lifetime of the downloaded CRL without nextUpdate
+	crlLifetime = 24 * time.Hour
+)
+
+// parseCRL parses DER or PEM encoded CRL
+func parseCRL(data []byte) (*x509.RevocationList, []byte, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	return crl, data, err
+}
+
+// downloadCRL fetches CRL from the HTTP distribution points of the certificate,
+// the next point is tried if one fails
+func (mon Monitor) downloadCRL(cert *x509.Certificate) ([]byte, string, error) {
+	client := http.Client{
+		Timeout: time.Duration(mon.Cfg.TLSTimeout) * time.Second,
+	}
+	lastErr := errors.New("No HTTP CRL distribution point")
+	for _, url := range cert.CRLDistributionPoints {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			continue
+		}
+		resp, err := client.Get(url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("CRL distribution point %s replied %s", url, resp.Status)
+			continue
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCRLSize))
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return data, url, nil
+	}
+	return nil, "", lastErr
+}
+
+// crlExpired reports whether nextUpdate of the CRL is passed, the CRL without nextUpdate does not expire
+func crlExpired(nextUpdate time.Time, now time.Time) bool {
+	return !nextUpdate.IsZero() && !now.Before(nextUpdate)
+}
+
+// crlFresh reports whether the cached CRL is used without the download, the downloaded CRL
+// without nextUpdate is refreshed after `crlLifetime`, CRL files are refreshed by `LoadCRLDirs`
+func crlFresh(cached *DBCRLRow, now time.Time) bool {
+	if cached.NextUpdate.IsZero() && !strings.HasPrefix(cached.URL, "file://") {
+		return now.Before(cached.ThisUpdate.Add(crlLifetime))
+	}
+	return !crlExpired(cached.NextUpdate, now)
+}
+
+// verifyCRL checks the CRL signature by the issuer and its validity period
+func verifyCRL(crl *x509.RevocationList, issuer *x509.Certificate, now time.Time) error {
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return fmt.Errorf("CRL signature is invalid: %s", err)
+	}
+	if crlExpired(crl.NextUpdate, now) {
+		return fmt.Errorf("CRL is expired since %s", crl.NextUpdate.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// cachedCRL returns the fresh cached CRL of the issuer, the entry which fails verification is dropped
+func (mon Monitor) cachedCRL(issuerHash string, issuer *x509.Certificate, now time.Time) *x509.RevocationList {
+	cached := mon.DB.GetCRL(issuerHash)
+	if cached == nil || !crlFresh(cached, now) {
+		return nil
+	}
+	crl, err := func() (*x509.RevocationList, error) {
+		der, err := hex.DecodeString(cached.CRL)
+		if err != nil {
+			return nil, err
+		}
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, err
+		}
+		return crl, verifyCRL(crl, issuer, now)
+	}()
+	if err != nil {
+		log.Printf("Dropped cached CRL %s: %s\n", cached.URL, err)
+		mon.DB.DeleteCRL(issuerHash)
+		return nil
+	}
+	return crl
+}
+
+// fetchCRL returns the CRL of the certificate issuer from the cache or from the distribution point,
+// CRLs are used only if they are signed by the issuer and not expired
+func (mon Monitor) fetchCRL(cert *x509.Certificate, issuer *x509.Certificate, now time.Time) (*x509.RevocationList, error) {
+	if issuer == nil {
+		return nil, nil
+	}
+	issuerHash := fingerprint(cert.RawIssuer)
+	if crl := mon.cachedCRL(issuerHash, issuer, now); crl != nil {
+		return crl, nil
+	}
+	if !mon.Cfg.CRL || len(cert.CRLDistributionPoints) == 0 {
+		return nil, nil
+	}
+
+	data, url, err := mon.downloadCRL(cert)
+	if err != nil {
+		return nil, err
+	}
+	crl, der, err := parseCRL(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyCRL(crl, issuer, now); err != nil {
+		return nil, fmt.Errorf("%s: %s", url, err)
+	}
+	mon.DB.InsertCRL(DBCRLRow{
+		IssuerHash: issuerHash,
+		URL:        url,
+		CRL:        hex.EncodeToString(der),
+		ThisUpdate: crl.ThisUpdate,
+		NextUpdate: crl.NextUpdate,
+	})
+	return crl, nil
+}
+
+// checkCRL marks the state certificates as revoked or not by the issuers CRLs
+func (mon Monitor) checkCRL(st *DBStateRow, certs []*x509.Certificate) {
+	now := time.Now()
+	for i, cert := range certs {
+		if isSelfSigned(cert) {
+			continue
+		}
+		crl, err := mon.fetchCRL(cert, mon.lookupIssuer(st, cert, certs, now), now)
+		if err != nil {
+			st.Description = st.Description + "\nCRL: " + err.Error()
+			continue
+		}
+		if crl == nil {
+			continue
+		}
+		st.Certificates[i].Revoked = CertNotRevoked
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				st.Certificates[i].Revoked = CertRevoked
+				st.Valid = InvalidState
+				st.Description = st.Description + fmt.Sprintf("\nCertificate %s is revoked at %s by CRL",
+					certName(cert), entry.RevocationTime.Format(time.RFC3339))
+				break
+			}
+		}
+	}
+}
+
+// LoadCRLDirs puts CRL files from the zones directories into the cache
+func (mon Monitor) LoadCRLDirs() {
+	for _, zone := range mon.Cfg.Zones {
+		if len(zone.CRLDir) == 0 {
+			continue
+		}
+		files, err := ioutil.ReadDir(zone.CRLDir)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			filename := filepath.Join(zone.CRLDir, file.Name())
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			crl, der, err := parseCRL(data)
+			if err != nil {
+				log.Printf("Failed to parse CRL %s: %s\n", filename, err)
+				continue
+			}
+			if crlExpired(crl.NextUpdate, time.Now()) {
+				log.Printf("CRL %s is expired since %s\n", filename, crl.NextUpdate.Format(time.RFC3339))
+				continue
+			}
+			issuerHash := fingerprint(crl.RawIssuer)
+			if cached := mon.DB.GetCRL(issuerHash); cached != nil && !crl.ThisUpdate.After(cached.ThisUpdate) {
+				continue
+			}
+			mon.DB.InsertCRL(DBCRLRow{
+				IssuerHash: issuerHash,
+				URL:        "file://" + filename,
+				CRL:        hex.EncodeToString(der),
+				ThisUpdate: crl.ThisUpdate,
+				NextUpdate: crl.NextUpdate,
+			})
+		}
+	}
+}
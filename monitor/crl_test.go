@@ -0,0 +1,151 @@
+package monitor
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testCRL returns the DER CRL of the issuer revoking the serials, the CRL has no nextUpdate if it is zero
+func testCRL(t *testing.T, issuer *x509.Certificate, key *ecdsa.PrivateKey, nextUpdate time.Time, serials ...*big.Int) []byte {
+	if nextUpdate.IsZero() {
+		return testCRLWithoutNextUpdate(t, issuer, key)
+	}
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: nextUpdate,
+	}
+	for _, serial := range serials {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries,
+			x509.RevocationListEntry{SerialNumber: serial, RevocationTime: time.Now().Add(-time.Minute)})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, issuer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// testCRLWithoutNextUpdate returns the empty DER CRL of the issuer without nextUpdate,
+// x509.CreateRevocationList always sets it
+func testCRLWithoutNextUpdate(t *testing.T, issuer *x509.Certificate, key *ecdsa.PrivateKey) []byte {
+	algorithm := pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}}
+	tbs, err := asn1.Marshal(struct {
+		Version    int
+		Signature  pkix.AlgorithmIdentifier
+		Issuer     asn1.RawValue
+		ThisUpdate time.Time `asn1:"utc"`
+	}{1, algorithm, asn1.RawValue{FullBytes: issuer.RawSubject}, time.Now().Add(-time.Minute).UTC()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(struct {
+		TBS       asn1.RawValue
+		Algorithm pkix.AlgorithmIdentifier
+		Signature asn1.BitString
+	}{asn1.RawValue{FullBytes: tbs}, algorithm, asn1.BitString{Bytes: signature, BitLength: len(signature) * 8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestVerifyCRL(t *testing.T) {
+	issuer, key := testCert(t, "Test CA", true, nil, nil, time.Now().Add(time.Hour))
+	_, otherKey := testCert(t, "Other CA", true, nil, nil, time.Now().Add(time.Hour))
+	now := time.Now()
+	tests := []struct {
+		name    string
+		key     *ecdsa.PrivateKey
+		next    time.Time
+		wantErr bool
+	}{
+		{"valid", key, now.Add(time.Hour), false},
+		{"without nextUpdate", key, time.Time{}, false},
+		{"expired", key, now.Add(-time.Second), true},
+		{"forged", otherKey, now.Add(time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crl, err := x509.ParseRevocationList(testCRL(t, issuer, tt.key, tt.next))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := verifyCRL(crl, issuer, now); (err != nil) != tt.wantErr {
+				t.Errorf("verifyCRL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFetchCRL(t *testing.T) {
+	issuer, key := testCert(t, "Test CA", true, nil, nil, time.Now().Add(time.Hour))
+	_, otherKey := testCert(t, "Other CA", true, nil, nil, time.Now().Add(time.Hour))
+	var downloads int
+	var leaf *x509.Certificate
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write(testCRL(t, issuer, key, time.Now().Add(time.Hour), leaf.SerialNumber))
+	}))
+	defer srv.Close()
+	leaf, _ = testCert(t, "leaf.example.com", false, issuer, key, time.Now().Add(time.Hour),
+		func(c *x509.Certificate) { c.CRLDistributionPoints = []string{srv.URL} })
+	issuerHash := fingerprint(leaf.RawIssuer)
+
+	tests := []struct {
+		name          string
+		cached        []byte
+		next          time.Time
+		download      bool
+		wantDownloads int
+		wantRevoked   bool
+	}{
+		{"cached", testCRL(t, issuer, key, time.Now().Add(time.Hour)), time.Now().Add(time.Hour), true, 0, false},
+		{"cached without nextUpdate", testCRL(t, issuer, key, time.Time{}), time.Time{}, true, 0, false},
+		{"forged cache", testCRL(t, issuer, otherKey, time.Now().Add(time.Hour)), time.Now().Add(time.Hour), true, 1, true},
+		{"forged cache without download", testCRL(t, issuer, otherKey, time.Now().Add(time.Hour)), time.Now().Add(time.Hour), false, 0, false},
+		{"expired cache", testCRL(t, issuer, key, time.Now().Add(-time.Second)), time.Now().Add(-time.Second), true, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mon := testMonitor(t)
+			mon.Cfg.CRL = tt.download
+			downloads = 0
+			mon.DB.InsertCRL(DBCRLRow{
+				IssuerHash: issuerHash,
+				URL:        "file:///crl/test.crl",
+				CRL:        hex.EncodeToString(tt.cached),
+				ThisUpdate: time.Now().Add(-time.Minute),
+				NextUpdate: tt.next,
+			})
+			crl, err := mon.fetchCRL(leaf, issuer, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if downloads != tt.wantDownloads {
+				t.Errorf("downloads = %d, want %d", downloads, tt.wantDownloads)
+			}
+			if revoked := crl != nil && len(crl.RevokedCertificateEntries) > 0; revoked != tt.wantRevoked {
+				t.Errorf("revoked = %v, want %v", revoked, tt.wantRevoked)
+			}
+			if !tt.download && crl == nil && mon.DB.GetCRL(issuerHash) != nil {
+				t.Error("forged cached CRL is not dropped")
+			}
+		})
+	}
+}
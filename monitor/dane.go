@@ -0,0 +1,262 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DANEOK means that the served chain matches a TLSA record
+	DANEOK = "ok"
+	// DANEMismatch means that the served chain matches no TLSA record
+	DANEMismatch = "mismatch"
+	// DANEMissing means that TLSA records are expected but not published
+	DANEMissing = "missing"
+	// DANEError means that TLSA records are failed to look up
+	DANEError = "error"
+
+	// DANE statuses of the state without TLSA records are empty
+
+	tlsaUsagePKIXTA = 0
+	tlsaUsagePKIXEE = 1
+	tlsaUsageDANETA = 2
+	tlsaUsageDANEEE = 3
+)
+
+// DANEStatuses lists all DANE statuses of the checked states
+var DANEStatuses = []string{DANEOK, DANEMismatch, DANEMissing, DANEError}
+
+// ValidDANEStatus reports whether the DANE status is known
+func ValidDANEStatus(status string) bool {
+	for _, s := range DANEStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsaEntry is the cached reply to TLSA lookup
+type tlsaEntry struct {
+	records []*dns.TLSA
+	secure  bool
+	expires time.Time
+}
+
+// tlsaCache keeps TLSA replies by the record name until their TTL expires
+type tlsaCache struct {
+	sync.Mutex
+	entries map[string]tlsaEntry
+}
+
+func newTLSACache() *tlsaCache {
+	return &tlsaCache{entries: make(map[string]tlsaEntry)}
+}
+
+func (c *tlsaCache) get(name string, now time.Time) (tlsaEntry, bool) {
+	if c == nil {
+		return tlsaEntry{}, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	entry, ok := c.entries[name]
+	if ok && !now.Before(entry.expires) {
+		delete(c.entries, name)
+		return tlsaEntry{}, false
+	}
+	return entry, ok
+}
+
+func (c *tlsaCache) put(name string, entry tlsaEntry) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.entries[name] = entry
+}
+
+// replyTTL returns the lowest TTL of the answer, the negative reply is cached by SOA of the authority section
+func replyTTL(reply *dns.Msg) (uint32, bool) {
+	var ttl uint32
+
+	found := false
+	lower := func(value uint32) {
+		if !found || value < ttl {
+			ttl = value
+		}
+		found = true
+	}
+	for _, rr := range reply.Answer {
+		lower(rr.Header().Ttl)
+	}
+	if len(reply.Answer) == 0 {
+		for _, rr := range reply.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				lower(soa.Hdr.Ttl)
+				lower(soa.Minttl)
+			}
+		}
+	}
+	return ttl, found
+}
+
+// lookupTLSA returns TLSA records of the host port and whether the reply is DNSSEC authenticated,
+// the records are asked from the validating resolver and cached by TTL
+func (mon Monitor) lookupTLSA(host string, port string) ([]*dns.TLSA, bool, error) {
+	name, err := dns.TLSAName(dns.Fqdn(strings.ToLower(host)), port, "tcp")
+	if err != nil {
+		return nil, false, err
+	}
+	now := time.Now()
+	if entry, ok := mon.tlsa.get(name, now); ok {
+		return entry.records, entry.secure, nil
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, dns.TypeTLSA)
+	msg.SetEdns0(4096, true)
+	msg.AuthenticatedData = true
+
+	reply, err := exchange(msg, mon.Cfg.validatingResolver(), time.Duration(mon.Cfg.TLSTimeout)*time.Second)
+	if err != nil {
+		return nil, false, err
+	}
+	if reply.Rcode != dns.RcodeSuccess && reply.Rcode != dns.RcodeNameError {
+		return nil, false, fmt.Errorf("TLSA lookup of %s is failed: %s", name, dns.RcodeToString[reply.Rcode])
+	}
+	records := make([]*dns.TLSA, 0, len(reply.Answer))
+	for _, rr := range reply.Answer {
+		if tlsa, ok := rr.(*dns.TLSA); ok {
+			records = append(records, tlsa)
+		}
+	}
+	if ttl, ok := replyTTL(reply); ok && ttl > 0 {
+		mon.tlsa.put(name, tlsaEntry{
+			records: records,
+			secure:  reply.AuthenticatedData,
+			expires: now.Add(time.Duration(ttl) * time.Second),
+		})
+	}
+	return records, reply.AuthenticatedData, nil
+}
+
+// matchTLSA reports whether the record matches the chain, the path is the served chain
+// completed with the trusted root, PKIX usages also require the valid chain and
+// DANE-TA usage requires the leaf to be issued through the matched trust anchor
+func matchTLSA(record *dns.TLSA, certs []*x509.Certificate, path []*x509.Certificate, pkixValid bool, now time.Time) bool {
+	switch record.Usage {
+	case tlsaUsageDANEEE:
+		return record.Verify(certs[0]) == nil
+	case tlsaUsagePKIXEE:
+		return pkixValid && record.Verify(certs[0]) == nil
+	case tlsaUsageDANETA:
+		for _, cert := range certs[1:] {
+			if record.Verify(cert) == nil && issuedBy(certs, cert, now) {
+				return true
+			}
+		}
+	case tlsaUsagePKIXTA:
+		if !pkixValid {
+			return false
+		}
+		for _, cert := range path[1:] {
+			if record.Verify(cert) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// issuedBy reports whether the leaf is verified by the trust anchor through the served certificates
+func issuedBy(certs []*x509.Certificate, anchor *x509.Certificate, now time.Time) bool {
+	roots := x509.NewCertPool()
+	roots.AddCert(anchor)
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+// daneChain drops the problems of the chain authenticated by DANE which make it invalid for PKIX
+func daneChain(problems []string) string {
+	kept := make([]string, 0, len(problems))
+	for _, problem := range problems {
+		if problem == ChainWrongOrder || problem == ChainExtraCerts {
+			kept = append(kept, problem)
+		}
+	}
+	if len(kept) == 0 {
+		return ChainOK
+	}
+	return strings.Join(kept, ",")
+}
+
+// daneExpected reports whether TLSA records must be published for the host
+func (mon Monitor) daneExpected(st *DBStateRow) bool {
+	if target := mon.Cfg.findTarget(st.Host, st.SNI); target != nil && target.DANE {
+		return true
+	}
+	zone := mon.Cfg.findZone(st.SNI)
+	return zone != nil && (zone.DANE || (zone.DANEMX && st.Protocol == ProtoSMTP))
+}
+
+// checkDANE verifies the served chain against TLSA records of the host which expects DANE and reports
+// whether the chain is authenticated by DNSSEC signed DANE-TA or DANE-EE record,
+// such chain is valid without the trusted root
+func (mon Monitor) checkDANE(st *DBStateRow, certs []*x509.Certificate, pkixValid bool) bool {
+	host, port, err := net.SplitHostPort(st.Host)
+	if err != nil || net.ParseIP(host) != nil || len(certs) == 0 || !mon.daneExpected(st) {
+		return false
+	}
+	records, secure, err := mon.lookupTLSA(host, port)
+	switch {
+	case err != nil:
+		st.DANE = DANEError
+		st.Description = st.Description + "\nDANE: " + err.Error()
+		return false
+	case len(records) == 0:
+		st.DANE = DANEMissing
+		st.Valid = InvalidState
+		st.Description = st.Description + fmt.Sprintf("\nDANE: TLSA records of %s:%s are missing", host, port)
+		return false
+	}
+
+	now := time.Now()
+	path := trustPath(certs, mon.Cfg.Roots(st.Host, st.SNI), now)
+	matched, authenticated := false, false
+	for _, record := range records {
+		if matchTLSA(record, certs, path, pkixValid, now) {
+			matched = true
+			authenticated = authenticated ||
+				(secure && (record.Usage == tlsaUsageDANETA || record.Usage == tlsaUsageDANEEE))
+		}
+	}
+	if !matched {
+		st.DANE = DANEMismatch
+		st.Valid = InvalidState
+		st.Description = st.Description + fmt.Sprintf("\nDANE: served chain matches none of %d TLSA records", len(records))
+		return false
+	}
+	st.DANE = DANEOK
+	if !secure {
+		st.Description = st.Description + "\nDANE: TLSA records are not DNSSEC authenticated"
+	} else if authenticated && !pkixValid {
+		st.Description = st.Description + "\nDANE: chain is authenticated by TLSA records"
+	}
+	return authenticated
+}
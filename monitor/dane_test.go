@@ -0,0 +1,179 @@
+package monitor
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testTLSA returns the record of the certificate with the usage, the full certificate is matched by SHA-256
+func testTLSA(name string, usage uint8, cert *x509.Certificate, ttl uint32) *dns.TLSA {
+	sum := sha256.Sum256(cert.Raw)
+	return &dns.TLSA{
+		Hdr:          dns.RR_Header{Name: name, Rrtype: dns.TypeTLSA, Class: dns.ClassINET, Ttl: ttl},
+		Usage:        usage,
+		Selector:     0,
+		MatchingType: 1,
+		Certificate:  hex.EncodeToString(sum[:]),
+	}
+}
+
+// testResolver serves the records, the replies to the secure names have AD bit,
+// it returns the address and the number of received queries
+func testResolver(t *testing.T, records map[string][]dns.RR, secure map[string]bool) (string, *int32) {
+	var queries int32
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			atomic.AddInt32(&queries, 1)
+			m := new(dns.Msg)
+			m.SetReply(r)
+			name := r.Question[0].Name
+			if rrs, ok := records[name]; ok {
+				m.Answer = rrs
+			} else {
+				m.Rcode = dns.RcodeNameError
+			}
+			m.AuthenticatedData = secure[name]
+			w.WriteMsg(m)
+		}),
+	}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+	return pc.LocalAddr().String(), &queries
+}
+
+func TestMatchTLSA(t *testing.T) {
+	root, inter, leaf := testChain(t)
+	other, _ := testCert(t, "Other", true, nil, nil, time.Now().AddDate(1, 0, 0))
+	name := "_443._tcp.leaf.example.com."
+	certs := []*x509.Certificate{leaf, inter}
+	path := []*x509.Certificate{leaf, inter, root}
+
+	tests := []struct {
+		name      string
+		record    *dns.TLSA
+		certs     []*x509.Certificate
+		pkixValid bool
+		want      bool
+	}{
+		{"DANE-EE", testTLSA(name, tlsaUsageDANEEE, leaf, 60), certs, false, true},
+		{"DANE-EE other", testTLSA(name, tlsaUsageDANEEE, inter, 60), certs, false, false},
+		{"PKIX-EE valid", testTLSA(name, tlsaUsagePKIXEE, leaf, 60), certs, true, true},
+		{"PKIX-EE invalid", testTLSA(name, tlsaUsagePKIXEE, leaf, 60), certs, false, false},
+		{"DANE-TA", testTLSA(name, tlsaUsageDANETA, inter, 60), certs, false, true},
+		{"DANE-TA not served", testTLSA(name, tlsaUsageDANETA, root, 60), certs, false, false},
+		{"DANE-TA not issuer", testTLSA(name, tlsaUsageDANETA, other, 60),
+			[]*x509.Certificate{leaf, inter, other}, false, false},
+		{"PKIX-TA root", testTLSA(name, tlsaUsagePKIXTA, root, 60), certs, true, true},
+		{"PKIX-TA invalid", testTLSA(name, tlsaUsagePKIXTA, root, 60), certs, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchTLSA(tt.record, tt.certs, path, tt.pkixValid, time.Now()); got != tt.want {
+				t.Errorf("matchTLSA() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDANE(t *testing.T) {
+	root, inter, leaf := testChain(t)
+	certs := []*x509.Certificate{leaf, inter}
+	records := map[string][]dns.RR{
+		"_443._tcp.ee.example.com.":       {testTLSA("_443._tcp.ee.example.com.", tlsaUsageDANEEE, leaf, 60)},
+		"_443._tcp.ta.example.com.":       {testTLSA("_443._tcp.ta.example.com.", tlsaUsageDANETA, inter, 60)},
+		"_443._tcp.insecure.example.com.": {testTLSA("_443._tcp.insecure.example.com.", tlsaUsageDANEEE, leaf, 60)},
+		"_443._tcp.pkix.example.com.":     {testTLSA("_443._tcp.pkix.example.com.", tlsaUsagePKIXTA, root, 60)},
+		"_443._tcp.other.example.com.":    {testTLSA("_443._tcp.other.example.com.", tlsaUsageDANEEE, inter, 60)},
+		"_443._tcp.plain.example.com.":    {testTLSA("_443._tcp.plain.example.com.", tlsaUsageDANEEE, inter, 60)},
+	}
+	secure := map[string]bool{
+		"_443._tcp.ee.example.com.":    true,
+		"_443._tcp.ta.example.com.":    true,
+		"_443._tcp.pkix.example.com.":  true,
+		"_443._tcp.other.example.com.": true,
+	}
+	mon := testMonitor(t)
+	var queries *int32
+	mon.Cfg.Resolver, queries = testResolver(t, records, secure)
+	mon.Cfg.Zones = []ZoneConfig{{Name: "example.com.", DANE: true}, {Name: "plain.example.com."}}
+
+	tests := []struct {
+		host          string
+		pkixValid     bool
+		wantDANE      string
+		wantValid     int
+		authenticated bool
+		lookup        bool
+	}{
+		{"ee.example.com", false, DANEOK, ValidState, true, true},
+		{"ta.example.com", false, DANEOK, ValidState, true, true},
+		{"insecure.example.com", false, DANEOK, ValidState, false, true},
+		{"pkix.example.com", false, DANEMismatch, InvalidState, false, true},
+		{"other.example.com", true, DANEMismatch, InvalidState, false, true},
+		{"none.example.com", true, DANEMissing, InvalidState, false, true},
+		{"plain.example.com", true, "", ValidState, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			atomic.StoreInt32(queries, 0)
+			st := &DBStateRow{Host: tt.host + ":443", SNI: tt.host, Protocol: ProtoTLS, Valid: ValidState}
+			authenticated := mon.checkDANE(st, certs, tt.pkixValid)
+			if st.DANE != tt.wantDANE || st.Valid != tt.wantValid || authenticated != tt.authenticated {
+				t.Errorf("checkDANE() = %v, DANE %q valid %d, want %v %q %d",
+					authenticated, st.DANE, st.Valid, tt.authenticated, tt.wantDANE, tt.wantValid)
+			}
+			if lookup := atomic.LoadInt32(queries) > 0; lookup != tt.lookup {
+				t.Errorf("TLSA lookup %v, want %v", lookup, tt.lookup)
+			}
+		})
+	}
+}
+
+func TestLookupTLSACache(t *testing.T) {
+	_, _, leaf := testChain(t)
+	records := map[string][]dns.RR{
+		"_443._tcp.cached.example.com.":   {testTLSA("_443._tcp.cached.example.com.", tlsaUsageDANEEE, leaf, 60)},
+		"_443._tcp.uncached.example.com.": {testTLSA("_443._tcp.uncached.example.com.", tlsaUsageDANEEE, leaf, 0)},
+	}
+	mon := testMonitor(t)
+	var queries *int32
+	mon.Cfg.Resolver, queries = testResolver(t, records, nil)
+
+	tests := []struct {
+		host string
+		want int32
+	}{
+		{"cached.example.com", 1},
+		{"uncached.example.com", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			atomic.StoreInt32(queries, 0)
+			for i := 0; i < 2; i++ {
+				found, _, err := mon.lookupTLSA(tt.host, "443")
+				if err != nil || len(found) != 1 {
+					t.Fatalf("lookupTLSA() = %v, %v", found, err)
+				}
+			}
+			if got := atomic.LoadInt32(queries); got != tt.want {
+				t.Errorf("%d queries, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,9 +3,12 @@ package monitor
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -26,29 +29,244 @@ const (
 )
 
 // DBStateRow represents table `states` row
+//	Findings - results of the state checks, they are written with the state, see table `state_findings`
+//	HTTPStatus, HTTPVersion - response to the HTTP probe, 0 if the probe is disabled or failed
+//	HSTS - Strict-Transport-Security header, HSTSMaxAge is -1 if max-age is missing
+//	HTTPRedirect - behaviour of plain HTTP on port 80, see `Redirect*`
+//	Failures, Pending - number of consecutive checks which found the Pending validity,
+//		Pending is meaningless if there are no failures
 type DBStateRow struct {
-	Certificates  []DBCertRow `json:"certificates"`
-	Description   string      `json:"description"`
-	Host          string      `json:"host"`
-	ID            int         `json:"id"`
-	LastDiscovery time.Time   `json:"lasDiscovery"`
-	SNI           string      `json:"sni"`
-	TS            time.Time   `json:"ts"`
-	Type          int         `json:"type"`
-	Valid         int         `json:"valid"`
+	Alert          int                 `json:"alert"`
+	ALPN           string              `json:"alpn"`
+	Backends       []DBBackendRow      `json:"backends"`
+	Certificates   []DBCertRow         `json:"certificates"`
+	Chain          string              `json:"chain"`
+	CipherSuite    string              `json:"cipherSuite"`
+	DANE           string              `json:"dane"`
+	Description    string              `json:"description"`
+	Distrusted     bool                `json:"distrusted"`
+	Error          string              `json:"error"`
+	ErrorCategory  string              `json:"errorCategory"`
+	Failures       int                 `json:"failures"`
+	Findings       []DBStateFindingRow `json:"findings,omitempty"`
+	Flaps          int                 `json:"flaps"`
+	Host           string              `json:"host"`
+	HSTS           string              `json:"hsts"`
+	HSTSMaxAge     int                 `json:"hstsMaxAge"`
+	HSTSPreload    bool                `json:"hstsPreload"`
+	HSTSSubdomains bool                `json:"hstsSubdomains"`
+	HTTPRedirect   string              `json:"httpRedirect"`
+	HTTPStatus     int                 `json:"httpStatus"`
+	HTTPVersion    string              `json:"httpVersion"`
+	ID             int                 `json:"id"`
+	Inconsistent   bool                `json:"inconsistent"`
+	LastDiscovery  time.Time           `json:"lasDiscovery"`
+	Latency        int                 `json:"latency"`
+	OCSP           string              `json:"ocsp"`
+	OCSPStapled    bool                `json:"ocspStapled"`
+	Pending        int                 `json:"pending"`
+	Protocol       string              `json:"protocol"`
+	Scan           bool                `json:"scan"`
+	Severity       string              `json:"severity"`
+	SNI            string              `json:"sni"`
+	TLSVersion     string              `json:"tlsVersion"`
+	TS             time.Time           `json:"ts"`
+	Type           int                 `json:"type"`
+	Valid          int                 `json:"valid"`
+	WeakTLS        bool                `json:"weakTLS"`
+
+	// held means that the check result is damped, only the error and failures are written
+	held bool
 }
 
 // DBCertRow represents table `certs` row
+//	DER - raw certificate, it is stored but not read with the row
+//	KeyAlgorithm, KeySize - public key algorithm and size in bits
+//	MaxPathLen - basic constraints path length, -1 if it is not limited
+//	SANs - subject alternative names, see table `cert_sans`
+//	Class - self-signed, private or public CA certificate, see `Cert*`
 type DBCertRow struct {
-	CommonName  string    `json:"commonName"`
-	Domains     string    `json:"domains"`
-	Expired     int       `json:"expired"`
+	Class                 string             `json:"class"`
+	CommonName            string             `json:"commonName"`
+	CRLDistributionPoints StringList         `json:"crlDistributionPoints"`
+	DER                   []byte             `json:"-"`
+	Domains               string             `json:"domains"`
+	Expired               int                `json:"expired"`
+	ExtKeyUsage           StringList         `json:"extKeyUsage"`
+	Findings              []DBCertFindingRow `json:"findings,omitempty"`
+	Fingerprint           string             `json:"fingerprint"`
+	ID                    int                `json:"id"`
+	IsCA                  bool               `json:"isCA"`
+	IssuerHash            string             `json:"issuerHash"`
+	IssuingURLs           StringList         `json:"issuingURLs"`
+	KeyAlgorithm          string             `json:"keyAlgorithm"`
+	KeySize               int                `json:"keySize"`
+	KeyUsage              StringList         `json:"keyUsage"`
+	MaxPathLen            int                `json:"maxPathLen"`
+	NotAfter              time.Time          `json:"notAfter"`
+	NotBefore             time.Time          `json:"notBefore"`
+	OCSPServers           StringList         `json:"ocspServers"`
+	Revoked               int                `json:"revoked"`
+	SANs                  []DBSANRow         `json:"sans"`
+	SerialNumber          string             `json:"serialNumber"`
+	Severity              string             `json:"severity,omitempty"`
+	SignatureAlgorithm    string             `json:"signatureAlgorithm"`
+	SPKIHash              string             `json:"spkiHash"`
+	SubjectHash           string             `json:"subjectHash"`
+}
+
+// DBSANRow represents table `cert_sans` row
+//	Type - name type (dns/ip/email/uri), see `SAN*`
+type DBSANRow struct {
+	Fingerprint string `json:"-"`
+	Type        string `json:"type"`
+	Value       string `json:"value"`
+}
+
+// DBDomainRow represents table `cert_domains` row
+//	Name - normalized DNS name of the certificate
+//	Parent - the name without the first label
+//	Wildcard - the name starts with `*.`
+type DBDomainRow struct {
+	Fingerprint string
+	Name        string
+	Parent      string
+	Wildcard    bool
+}
+
+// DBDomainMatch represents the certificate found by the name
+//	Match - how the certificate matches the name, see `Match*`
+//	Name - the matched certificate name
+//	States - states which serve the certificate
+type DBDomainMatch struct {
+	Certificate DBCertRow    `json:"certificate"`
+	Match       string       `json:"match"`
+	Name        string       `json:"name"`
+	States      []DBStateRow `json:"states"`
+}
+
+// StringList is a list which is stored as newline separated text
+type StringList []string
+
+// Scan implements sql.Scanner
+func (l *StringList) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case string:
+		*l = splitList(v)
+	case []byte:
+		*l = splitList(string(v))
+	case nil:
+		*l = StringList{}
+	default:
+		return fmt.Errorf("Cannot scan %T into StringList", src)
+	}
+	return nil
+}
+
+func (l StringList) String() string {
+	return strings.Join(l, "\n")
+}
+
+// DBCertFindingRow represents table `cert_findings` row
+//	Rule - name of the lint rule, see `LintRules`
+//	Severity - finding severity (notice/warning/error)
+type DBCertFindingRow struct {
+	Fingerprint string `json:"fingerprint"`
+	Message     string `json:"message"`
+	Rule        string `json:"rule"`
+	Severity    string `json:"severity"`
+}
+
+// DBStateFindingRow represents table `state_findings` row
+//	Rule - name of the check, e.g. `FindingCAA`
+//	Severity - finding severity (notice/warning/error)
+type DBStateFindingRow struct {
+	Message  string `json:"message"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	StateID  int    `json:"stateId"`
+}
+
+// DBBackendRow represents table `state_backends` row
+//	Address - resolved address of the state host
+//	Error - probe failure
+//	ErrorCategory - probe failure category, see `Error*`
+//	Fingerprint - fingerprint of the served leaf certificate
+type DBBackendRow struct {
+	Address       string `json:"address"`
+	Error         string `json:"error"`
+	ErrorCategory string `json:"errorCategory"`
+	Fingerprint   string `json:"fingerprint"`
+}
+
+// DBScanRow represents table `scans` row
+//	CipherSuites - accepted cipher suites prefixed with the protocol version
+//	Findings - found misconfigurations
+//	Versions - accepted protocol versions
+type DBScanRow struct {
+	CipherSuites []string  `json:"cipherSuites"`
+	Findings     []string  `json:"findings"`
+	StateID      int       `json:"stateId"`
+	TS           time.Time `json:"ts"`
+	Versions     []string  `json:"versions"`
+}
+
+// DBPinRow represents table `state_pins` row, see `Pin`
+type DBPinRow struct {
+	Pin
+	StateID int `json:"stateId"`
+}
+
+// DBHistoryRow represents table `state_cert_history` row
+//	Position - index of the certificate in the served chain, 0 is the leaf
+//	FirstSeen, LastSeen - the first and the last checks which found the certificate
+type DBHistoryRow struct {
+	Fingerprint string    `json:"fingerprint"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+	Position    int       `json:"position"`
+	StateID     int       `json:"stateId"`
+}
+
+// DBEventRow represents table `state_events` row
+//	Type - event type, see `Event*`
+//	Fingerprint - the currently served leaf certificate
+//	Previous - the previously served leaf certificate
+type DBEventRow struct {
+	Description string    `json:"description"`
 	Fingerprint string    `json:"fingerprint"`
 	ID          int       `json:"id"`
-	IssuerHash  string    `json:"issuerHash"`
-	NotAfter    time.Time `json:"notAfter"`
-	NotBefore   time.Time `json:"notBefore"`
-	SubjectHash string    `json:"subjectHash"`
+	Previous    string    `json:"previous"`
+	StateID     int       `json:"stateId"`
+	TS          time.Time `json:"ts"`
+	Type        string    `json:"type"`
+}
+
+// DBTimeline represents rotation timeline of the state
+type DBTimeline struct {
+	Certificates []DBHistoryRow `json:"certificates"`
+	Events       []DBEventRow   `json:"events"`
+	StateID      int            `json:"stateId"`
+}
+
+// DBCRLRow represents table `crls` row
+//	CRL - hex encoded DER revocation list
+//	URL - source of the list
+type DBCRLRow struct {
+	CRL        string
+	IssuerHash string
+	NextUpdate time.Time
+	ThisUpdate time.Time
+	URL        string
+}
+
+// DBOCSPRow represents table `ocsp_responses` row
+//	Response - hex encoded DER response
+type DBOCSPRow struct {
+	Fingerprint string
+	NextUpdate  time.Time
+	Response    string
+	ThisUpdate  time.Time
 }
 
 type dbwrapper struct {
@@ -64,18 +282,45 @@ type DBWrapper interface {
 	SingleWrite(sql string) (ch chan error)
 
 	DeleteCertificateBy(where string) error
+	DeleteCRL(issuerHash string) error
 	DeleteExclude(host string, sni string) error
+	DeletePin(stateID int) error
 	DeleteStateBy(where string) error
 	GetCertificateByID(id int) *DBCertRow
+	GetCertificateDERByID(id int) [][]byte
+	GetChainDERByStateID(id int) [][]byte
 	GetCertificatesBy(where string) []DBCertRow
 	GetCertificatesByExpire(expire int) []DBCertRow
+	GetCertificatesByKey(algorithm string, size int, issuerHash string) []DBCertRow
+	GetCertificatesByClass(class string) []DBCertRow
+	GetCRL(issuerHash string) *DBCRLRow
+	GetFindingsByCertID(id int) []DBCertFindingRow
+	GetFindingsByStateID(id int) []DBStateFindingRow
+	GetOCSPResponse(fingerprint string) *DBOCSPRow
+	GetPinByStateID(id int) *DBPinRow
 	GetStateCertsBy(where string) []DBStateRow
 	GetStatesBy(where string) []DBStateRow
 	GetStatesByExpire(expire int) []DBStateRow
 	GetStateByID(id int) *DBStateRow
+	GetScanByStateID(id int) *DBScanRow
+	GetBackendsByStateID(id int) []DBBackendRow
 	GetStatesByValid(valid int) []DBStateRow
+	GetStatesByCertificate(fingerprint string) []DBStateRow
+	GetDistrustedStates() []DBStateRow
+	GetStatesByDANE(status string) []DBStateRow
+	GetStateCountsByCertificate() map[string]int
+	GetStateNamesByCertificate(fingerprints []string) map[string][]DBStateRow
+	GetTimelineByStateID(id int) *DBTimeline
+	GetStatesByError(category string) []DBStateRow
+	GetStatesBySeverity(severity string) []DBStateRow
+	GetStateCertsBySeverity(severity string) []DBStateRow
 	InsertCert(cert DBCertRow) error
+	SearchDomain(name string) []DBDomainMatch
+	InsertCRL(crl DBCRLRow) error
 	InsertExclude(host string, sni string) error
+	InsertOCSPResponse(resp DBOCSPRow) error
+	InsertPin(pin DBPinRow) error
+	InsertScan(scan DBScanRow) error
 	InsertState(state DBStateRow) error
 	UpdateState(state *DBStateRow) error
 	UpdateStateLastDiscovery(state *DBStateRow) error
@@ -89,6 +334,94 @@ type DBWriteTask struct {
 	SQL string
 }
 
+// migrations extend tables of the existing database, since
+// `CREATE TABLE IF NOT EXISTS` leaves them as is
+var migrations = []string{
+	`ALTER TABLE states ADD COLUMN proto text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN chain text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN ocsp text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN ocsp_stapled integer DEFAULT 0`,
+	`ALTER TABLE certs ADD COLUMN revoked integer DEFAULT -1`,
+	`ALTER TABLE states ADD COLUMN tls_version text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN cipher_suite text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN alpn text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN latency integer DEFAULT 0`,
+	`ALTER TABLE states ADD COLUMN weak_tls integer DEFAULT 0`,
+	`ALTER TABLE states ADD COLUMN scan integer DEFAULT 0`,
+	`ALTER TABLE states ADD COLUMN inconsistent integer DEFAULT 0`,
+	`ALTER TABLE states ADD COLUMN error_category text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN error text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN alert integer DEFAULT 0`,
+	`ALTER TABLE state_backends ADD COLUMN error_category text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN failures integer DEFAULT 0`,
+	`ALTER TABLE states ADD COLUMN flaps integer DEFAULT 0`,
+	`ALTER TABLE states ADD COLUMN severity text DEFAULT 'unknown'`,
+	`ALTER TABLE certs ADD COLUMN spki_hash text DEFAULT ''`,
+	`ALTER TABLE certs ADD COLUMN der text DEFAULT ''`,
+	`ALTER TABLE certs ADD COLUMN serial text DEFAULT ''`,
+	`ALTER TABLE certs ADD COLUMN key_algorithm text DEFAULT ''`,
+	`ALTER TABLE certs ADD COLUMN key_size integer DEFAULT 0`,
+	`ALTER TABLE certs ADD COLUMN signature_algorithm text DEFAULT ''`,
+	`ALTER TABLE certs ADD COLUMN key_usage text DEFAULT ''`,
+	`ALTER TABLE certs ADD COLUMN ext_key_usage text DEFAULT ''`,
+	`ALTER TABLE certs ADD COLUMN ocsp_servers text DEFAULT ''`,
+	`ALTER TABLE certs ADD COLUMN issuing_urls text DEFAULT ''`,
+	`ALTER TABLE certs ADD COLUMN crl_points text DEFAULT ''`,
+	`ALTER TABLE certs ADD COLUMN is_ca integer DEFAULT 0`,
+	`ALTER TABLE certs ADD COLUMN max_path_len integer DEFAULT -1`,
+	`CREATE INDEX IF NOT EXISTS certs_spki_dx ON certs (spki_hash)`,
+	`ALTER TABLE certs ADD COLUMN class text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN distrusted integer DEFAULT 0`,
+	`ALTER TABLE states ADD COLUMN dane text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN http_status integer DEFAULT 0`,
+	`ALTER TABLE states ADD COLUMN http_version text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN hsts text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN hsts_max_age integer DEFAULT -1`,
+	`ALTER TABLE states ADD COLUMN hsts_subdomains integer DEFAULT 0`,
+	`ALTER TABLE states ADD COLUMN hsts_preload integer DEFAULT 0`,
+	`ALTER TABLE states ADD COLUMN http_redirect text DEFAULT ''`,
+	`ALTER TABLE states ADD COLUMN pending integer DEFAULT 0`,
+}
+
+// stateColumns lists `states` columns which are read by `stateFields`
+const stateColumns = `host, sni, proto, type, valid, description, ts, chain, ocsp, ocsp_stapled,
+	tls_version, cipher_suite, alpn, latency, weak_tls, scan, inconsistent, error_category, error, alert,
+	failures, flaps, severity, distrusted, dane, http_status, http_version, hsts, hsts_max_age, hsts_subdomains,
+	hsts_preload, http_redirect, pending`
+
+// certColumns lists `vCerts` columns which are read by `certFields`
+const certColumns = `fingerprint, subject_hash, issuer_hash, common_name, domains, not_after, not_before, expired,
+	revoked, spki_hash, serial, key_algorithm, key_size, signature_algorithm, key_usage, ext_key_usage,
+	ocsp_servers, issuing_urls, crl_points, is_ca, max_path_len, class`
+
+func certFields(c *DBCertRow) []interface{} {
+	return []interface{}{&c.Fingerprint, &c.SubjectHash, &c.IssuerHash, &c.CommonName, &c.Domains, &c.NotAfter,
+		&c.NotBefore, &c.Expired, &c.Revoked, &c.SPKIHash, &c.SerialNumber, &c.KeyAlgorithm, &c.KeySize,
+		&c.SignatureAlgorithm, &c.KeyUsage, &c.ExtKeyUsage, &c.OCSPServers, &c.IssuingURLs,
+		&c.CRLDistributionPoints, &c.IsCA, &c.MaxPathLen, &c.Class}
+}
+
+// qualifyColumns prefixes the columns list with the table alias
+func qualifyColumns(columns string, alias string) string {
+	fields := strings.Split(columns, ",")
+	for i, field := range fields {
+		fields[i] = alias + "." + strings.TrimSpace(field)
+	}
+	return strings.Join(fields, ", ")
+}
+
+func stateFields(s *DBStateRow) []interface{} {
+	return []interface{}{&s.Host, &s.SNI, &s.Protocol, &s.Type, &s.Valid, &s.Description, &s.TS, &s.Chain,
+		&s.OCSP, &s.OCSPStapled, &s.TLSVersion, &s.CipherSuite, &s.ALPN, &s.Latency, &s.WeakTLS,
+		&s.Scan, &s.Inconsistent, &s.ErrorCategory, &s.Error, &s.Alert, &s.Failures, &s.Flaps,
+		&s.Severity, &s.Distrusted, &s.DANE, &s.HTTPStatus, &s.HTTPVersion, &s.HSTS, &s.HSTSMaxAge,
+		&s.HSTSSubdomains, &s.HSTSPreload, &s.HTTPRedirect, &s.Pending}
+}
+
+func escapeSQL(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
+
 func timestampToSQLite(ts time.Time) string {
 	return ts.Format(time.RFC3339)
 }
@@ -189,29 +522,143 @@ func (dbw *dbwrapper) InitDB() error {
 		state_id integer,
 		fingerprint text
 	);
+	CREATE INDEX IF NOT EXISTS state_certs_fingerprint_dx
+		ON state_certs (fingerprint);
+	CREATE TABLE IF NOT EXISTS ocsp_responses(
+		fingerprint text not null primary key,
+		response text,
+		this_update timestamp,
+		next_update timestamp
+	);
+	CREATE TABLE IF NOT EXISTS crls(
+		issuer_hash text not null primary key,
+		url text,
+		crl text,
+		this_update timestamp,
+		next_update timestamp
+	);
+	CREATE TABLE IF NOT EXISTS state_backends(
+		id integer not null primary key,
+		state_id integer,
+		address text,
+		fingerprint text,
+		error text
+	);
+	CREATE INDEX IF NOT EXISTS state_backends_dx
+		ON state_backends (state_id);
+	CREATE TABLE IF NOT EXISTS scans(
+		state_id integer not null primary key,
+		ts timestamp,
+		versions text,
+		cipher_suites text,
+		findings text
+	);
+	CREATE TABLE IF NOT EXISTS cert_sans(
+		id integer not null primary key,
+		fingerprint text not null,
+		type text,
+		value text
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS cert_sans_dx
+		ON cert_sans (fingerprint, type, value);
+	CREATE TABLE IF NOT EXISTS cert_domains(
+		id integer not null primary key,
+		fingerprint text not null,
+		name text not null,
+		parent text,
+		wildcard integer DEFAULT 0
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS cert_domains_dx
+		ON cert_domains (fingerprint, name);
+	CREATE INDEX IF NOT EXISTS cert_domains_name_dx
+		ON cert_domains (name);
+	CREATE INDEX IF NOT EXISTS cert_domains_parent_dx
+		ON cert_domains (parent, wildcard);
+	CREATE TABLE IF NOT EXISTS cert_findings(
+		id integer not null primary key,
+		fingerprint text not null,
+		rule text,
+		severity text,
+		message text
+	);
+	CREATE INDEX IF NOT EXISTS cert_findings_dx
+		ON cert_findings (fingerprint);
+	CREATE TABLE IF NOT EXISTS state_findings(
+		id integer not null primary key,
+		state_id integer not null,
+		rule text,
+		severity text,
+		message text
+	);
+	CREATE INDEX IF NOT EXISTS state_findings_dx
+		ON state_findings (state_id);
+	CREATE TABLE IF NOT EXISTS state_pins(
+		state_id integer not null primary key,
+		spki_hashes text,
+		issuer_hashes text,
+		domains text
+	);
+	CREATE TABLE IF NOT EXISTS state_cert_history(
+		id integer not null primary key,
+		state_id integer not null,
+		fingerprint text not null,
+		position integer,
+		first_seen timestamp,
+		last_seen timestamp
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS state_cert_history_dx
+		ON state_cert_history (state_id, fingerprint);
+	CREATE TABLE IF NOT EXISTS state_events(
+		id integer not null primary key,
+		state_id integer not null,
+		ts timestamp,
+		type text,
+		fingerprint text,
+		previous text,
+		description text
+	);
+	CREATE INDEX IF NOT EXISTS state_events_dx
+		ON state_events (state_id);
 	CREATE TABLE IF NOT EXISTS excludes (
 		id integer not null primary key,
 		host text not null,
 		sni text
 	);
-	CREATE VIEW IF NOT EXISTS vCerts AS
-		SELECT certs.*, (strftime('%s', not_after) - strftime('%s', 'now')) / (24 * 3600) AS expired
+	`
+	_, err := dbw.Exec(sql)
+	if err != nil {
+		log.Println(err)
+	}
+	dbw.migrate()
+
+	views := fmt.Sprintf(`
+	DROP VIEW IF EXISTS vCerts;
+	CREATE VIEW vCerts AS
+		SELECT certs.*, (strftime('%%s', not_after) - strftime('%%s', 'now')) / (24 * 3600) AS expired
 		FROM certs;
-	CREATE VIEW IF NOT EXISTS vStates AS
+	DROP VIEW IF EXISTS vStates;
+	CREATE VIEW vStates AS
 		SELECT 
-			s.id AS state_id, host, sni, type, valid, description,
-			c.id as cert_id, c.fingerprint, issuer_hash, subject_hash, common_name, domains, not_after, not_before, expired
+			s.id AS state_id, s.*,
+			c.id as cert_id, %s
 		FROM states AS s
 			INNER JOIN state_certs AS sc ON s.id = sc.state_id
 			INNER JOIN vCerts AS c ON c.fingerprint = sc.fingerprint;
-	`
-	_, err := dbw.Exec(sql)
-	if err != nil {
+	`, qualifyColumns(certColumns, "c"))
+	if _, err := dbw.Exec(views); err != nil {
 		log.Println(err)
 	}
 	return nil
 }
 
+func (dbw *dbwrapper) migrate() {
+	for _, sql := range migrations {
+		if _, err := dbw.Exec(sql); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			log.Println(err)
+		}
+	}
+}
+
 func (dbw *dbwrapper) DeleteCertificateBy(where string) error {
 	if len(where) == 0 {
 		return errors.New("Condition for a DELETE query is empty")
@@ -238,10 +685,9 @@ func (dbw *dbwrapper) GetCertificatesBy(where string) []DBCertRow {
 	var c DBCertRow
 	sql := fmt.Sprintf(`
 		SELECT 
-			id, fingerprint, subject_hash, issuer_hash, common_name, domains, 
-			not_after, not_before, expired
+			id, %s
 		FROM vCerts %s
-	`, where)
+	`, certColumns, where)
 
 	certs := make([]DBCertRow, 0, 1)
 	rows, err := dbw.Query(sql)
@@ -251,17 +697,53 @@ func (dbw *dbwrapper) GetCertificatesBy(where string) []DBCertRow {
 	}
 	defer rows.Close()
 	for rows.Next() {
-		if err := rows.Scan(&c.ID, &c.Fingerprint, &c.SubjectHash, &c.IssuerHash, &c.CommonName,
-			&c.Domains, &c.NotAfter, &c.NotBefore, &c.Expired); err != nil {
+		if err := rows.Scan(append([]interface{}{&c.ID}, certFields(&c)...)...); err != nil {
 			log.Println(err)
 			break
 		}
 		certs = append(certs, c)
 	}
+	rows.Close()
+	dbw.loadSANs(certs)
 	return certs
 
 }
 
+// loadSANs fills subject alternative names of the certificates
+func (dbw *dbwrapper) loadSANs(certs []DBCertRow) {
+	var san DBSANRow
+
+	if len(certs) == 0 {
+		return
+	}
+	index := make(map[string][]int)
+	fingerprints := make([]string, 0, len(certs))
+	for i := range certs {
+		certs[i].SANs = make([]DBSANRow, 0)
+		index[certs[i].Fingerprint] = append(index[certs[i].Fingerprint], i)
+		fingerprints = append(fingerprints, "'"+certs[i].Fingerprint+"'")
+	}
+	sql := fmt.Sprintf(`
+		SELECT fingerprint, type, value FROM cert_sans
+		WHERE fingerprint IN (%s) ORDER BY id
+	`, strings.Join(fingerprints, ", "))
+	rows, err := dbw.Query(sql)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&san.Fingerprint, &san.Type, &san.Value); err != nil {
+			log.Println(err)
+			break
+		}
+		for _, i := range index[san.Fingerprint] {
+			certs[i].SANs = append(certs[i].SANs, san)
+		}
+	}
+}
+
 func (dbw *dbwrapper) GetCertificateByID(id int) *DBCertRow {
 	certs := dbw.GetCertificatesBy(fmt.Sprintf("WHERE ID=%d", id))
 	if len(certs) == 0 {
@@ -270,10 +752,66 @@ func (dbw *dbwrapper) GetCertificateByID(id int) *DBCertRow {
 	return &(certs[0])
 }
 
+// queryDER returns decoded hex DER of the query rows
+func (dbw *dbwrapper) queryDER(sql string) [][]byte {
+	var der string
+
+	ders := make([][]byte, 0, 1)
+	rows, err := dbw.Query(sql)
+	if err != nil {
+		log.Println(err)
+		return ders
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&der); err != nil {
+			log.Println(err)
+			break
+		}
+		if data, err := hex.DecodeString(der); err == nil && len(data) != 0 {
+			ders = append(ders, data)
+		}
+	}
+	return ders
+}
+
+func (dbw *dbwrapper) GetCertificateDERByID(id int) [][]byte {
+	return dbw.queryDER(fmt.Sprintf(`SELECT der FROM certs WHERE id=%d`, id))
+}
+
+func (dbw *dbwrapper) GetChainDERByStateID(id int) [][]byte {
+	return dbw.queryDER(fmt.Sprintf(`
+		SELECT c.der FROM state_certs AS sc
+			INNER JOIN certs AS c ON c.fingerprint = sc.fingerprint
+		WHERE sc.state_id=%d ORDER BY sc.id
+	`, id))
+}
+
 func (dbw *dbwrapper) GetCertificatesByExpire(expired int) []DBCertRow {
 	return dbw.GetCertificatesBy(fmt.Sprintf("WHERE expired < %d", expired))
 }
 
+func (dbw *dbwrapper) GetCertificatesByKey(algorithm string, size int, issuerHash string) []DBCertRow {
+	conditions := make([]string, 0, 3)
+	if len(algorithm) != 0 {
+		conditions = append(conditions, fmt.Sprintf("key_algorithm='%s'", escapeSQL(algorithm)))
+	}
+	if size != 0 {
+		conditions = append(conditions, fmt.Sprintf("key_size=%d", size))
+	}
+	if len(issuerHash) != 0 {
+		conditions = append(conditions, fmt.Sprintf("issuer_hash='%s'", escapeSQL(issuerHash)))
+	}
+	if len(conditions) == 0 {
+		return dbw.GetCertificatesBy("")
+	}
+	return dbw.GetCertificatesBy("WHERE " + strings.Join(conditions, " AND "))
+}
+
+func (dbw *dbwrapper) GetCertificatesByClass(class string) []DBCertRow {
+	return dbw.GetCertificatesBy(fmt.Sprintf("WHERE class='%s'", escapeSQL(class)))
+}
+
 func (dbw *dbwrapper) GetStateCertsBy(where string) []DBStateRow {
 	var (
 		s DBStateRow
@@ -281,9 +819,9 @@ func (dbw *dbwrapper) GetStateCertsBy(where string) []DBStateRow {
 	)
 	sql := fmt.Sprintf(`
 		SELECT 
-		state_id, host, sni, type, valid, description,
-		cert_id, fingerprint, subject_hash, issuer_hash, common_name, domains, not_after, not_before, expired
-	FROM vStates %s`, where)
+		state_id, %s,
+		cert_id, %s
+	FROM vStates %s`, stateColumns, certColumns, where)
 	rows, err := dbw.Query(sql)
 	if err != nil {
 		log.Println(err)
@@ -295,9 +833,10 @@ func (dbw *dbwrapper) GetStateCertsBy(where string) []DBStateRow {
 	for rows.Next() {
 		c = DBCertRow{}
 		s = DBStateRow{}
-		if err := rows.Scan(
-			&s.ID, &s.Host, &s.SNI, &s.Type, &s.Valid, &s.Description,
-			&c.ID, &c.Fingerprint, &c.SubjectHash, &c.IssuerHash, &c.CommonName, &c.Domains, &c.NotAfter, &c.NotBefore, &c.Expired); err != nil {
+		fields := append([]interface{}{&s.ID}, stateFields(&s)...)
+		fields = append(fields, &c.ID)
+		fields = append(fields, certFields(&c)...)
+		if err := rows.Scan(fields...); err != nil {
 			log.Println(err)
 			break
 		}
@@ -322,8 +861,8 @@ func (dbw *dbwrapper) GetStatesBy(where string) []DBStateRow {
 
 	sql := fmt.Sprintf(`
 		SELECT 
-			id, host, sni, valid, description, ts, type
-		FROM states %s`, where)
+			id, %s
+		FROM states %s`, stateColumns, where)
 	rows, err := dbw.Query(sql)
 	if err != nil {
 		log.Println(err)
@@ -332,8 +871,7 @@ func (dbw *dbwrapper) GetStatesBy(where string) []DBStateRow {
 	defer rows.Close()
 	states := make([]DBStateRow, 0, 1)
 	for rows.Next() {
-		if err := rows.Scan(&s.ID, &s.Host, &s.SNI, &s.Valid, &s.Description,
-			&s.TS, &s.Type); err != nil {
+		if err := rows.Scan(append([]interface{}{&s.ID}, stateFields(&s)...)...); err != nil {
 			log.Println(err)
 			break
 		}
@@ -350,14 +888,172 @@ func (dbw *dbwrapper) GetStatesByValid(valid int) []DBStateRow {
 	return dbw.GetStatesBy(fmt.Sprintf("WHERE valid=%d", valid))
 }
 
+func (dbw *dbwrapper) GetStatesByCertificate(fingerprint string) []DBStateRow {
+	return dbw.GetStatesBy(fmt.Sprintf(
+		"WHERE id IN (SELECT state_id FROM state_certs WHERE fingerprint='%s')", escapeSQL(fingerprint)))
+}
+
+// GetStateCountsByCertificate returns numbers of states serving the certificates by fingerprint
+func (dbw *dbwrapper) GetStateCountsByCertificate() map[string]int {
+	var (
+		fp    string
+		count int
+	)
+
+	counts := make(map[string]int)
+	rows, err := dbw.Query(`SELECT fingerprint, COUNT(DISTINCT state_id) FROM state_certs GROUP BY fingerprint`)
+	if err != nil {
+		log.Println(err)
+		return counts
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&fp, &count); err != nil {
+			log.Println(err)
+			break
+		}
+		counts[fp] = count
+	}
+	return counts
+}
+
+// GetStateNamesByCertificate returns host and SNI of the states serving the certificates by fingerprint
+func (dbw *dbwrapper) GetStateNamesByCertificate(fingerprints []string) map[string][]DBStateRow {
+	var fp string
+
+	states := make(map[string][]DBStateRow)
+	if len(fingerprints) == 0 {
+		return states
+	}
+	quoted := make([]string, 0, len(fingerprints))
+	for _, fp := range fingerprints {
+		quoted = append(quoted, "'"+escapeSQL(fp)+"'")
+	}
+	rows, err := dbw.Query(fmt.Sprintf(`
+		SELECT DISTINCT sc.fingerprint, s.id, s.host, s.sni FROM state_certs AS sc
+			INNER JOIN states AS s ON s.id = sc.state_id
+		WHERE sc.fingerprint IN (%s)`, strings.Join(quoted, ",")))
+	if err != nil {
+		log.Println(err)
+		return states
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var st DBStateRow
+		if err := rows.Scan(&fp, &st.ID, &st.Host, &st.SNI); err != nil {
+			log.Println(err)
+			break
+		}
+		states[fp] = append(states[fp], st)
+	}
+	return states
+}
+
+func (dbw *dbwrapper) GetDistrustedStates() []DBStateRow {
+	return dbw.GetStatesBy("WHERE distrusted=1")
+}
+
+func (dbw *dbwrapper) GetStatesByDANE(status string) []DBStateRow {
+	return dbw.GetStatesBy(fmt.Sprintf("WHERE dane='%s'", escapeSQL(status)))
+}
+
+func (dbw *dbwrapper) GetStatesByError(category string) []DBStateRow {
+	return dbw.GetStatesBy(fmt.Sprintf("WHERE error_category='%s'", escapeSQL(category)))
+}
+
+func (dbw *dbwrapper) GetStatesBySeverity(severity string) []DBStateRow {
+	return dbw.GetStatesBy(fmt.Sprintf("WHERE severity='%s'", escapeSQL(severity)))
+}
+
+func (dbw *dbwrapper) GetStateCertsBySeverity(severity string) []DBStateRow {
+	return dbw.GetStateCertsBy(fmt.Sprintf("WHERE severity='%s'", escapeSQL(severity)))
+}
+
 func (dbw *dbwrapper) GetStateByID(id int) *DBStateRow {
 	states := dbw.GetStatesBy(fmt.Sprintf("WHERE id=%d", id))
 	if len(states) == 0 {
 		return nil
 	}
+	states[0].Backends = dbw.GetBackendsByStateID(id)
 	return &(states[0])
 }
 
+func (dbw *dbwrapper) GetBackendsByStateID(id int) []DBBackendRow {
+	var b DBBackendRow
+
+	sql := fmt.Sprintf(`
+		SELECT address, fingerprint, error, error_category
+		FROM state_backends WHERE state_id=%d
+	`, id)
+	backends := make([]DBBackendRow, 0, 1)
+	rows, err := dbw.Query(sql)
+	if err != nil {
+		log.Println(err)
+		return backends
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&b.Address, &b.Fingerprint, &b.Error, &b.ErrorCategory); err != nil {
+			log.Println(err)
+			break
+		}
+		backends = append(backends, b)
+	}
+	return backends
+}
+
+func (dbw *dbwrapper) GetFindingsByCertID(id int) []DBCertFindingRow {
+	var f DBCertFindingRow
+
+	sql := fmt.Sprintf(`
+		SELECT f.fingerprint, f.rule, f.severity, f.message
+		FROM cert_findings AS f
+			INNER JOIN certs AS c ON c.fingerprint = f.fingerprint
+		WHERE c.id=%d
+	`, id)
+	findings := make([]DBCertFindingRow, 0, 1)
+	rows, err := dbw.Query(sql)
+	if err != nil {
+		log.Println(err)
+		return findings
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&f.Fingerprint, &f.Rule, &f.Severity, &f.Message); err != nil {
+			log.Println(err)
+			break
+		}
+		findings = append(findings, f)
+	}
+	return findings
+}
+
+func (dbw *dbwrapper) GetFindingsByStateID(id int) []DBStateFindingRow {
+	var f DBStateFindingRow
+
+	sql := fmt.Sprintf(`
+		SELECT state_id, rule, severity, message
+		FROM state_findings
+		WHERE state_id=%d
+		ORDER BY id
+	`, id)
+	findings := make([]DBStateFindingRow, 0, 1)
+	rows, err := dbw.Query(sql)
+	if err != nil {
+		log.Println(err)
+		return findings
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&f.StateID, &f.Rule, &f.Severity, &f.Message); err != nil {
+			log.Println(err)
+			break
+		}
+		findings = append(findings, f)
+	}
+	return findings
+}
+
 func (dbw *dbwrapper) InsertCert(cert DBCertRow) error {
 
 	sql := fmt.Sprintf(`
@@ -370,11 +1066,251 @@ func (dbw *dbwrapper) InsertCert(cert DBCertRow) error {
 	`, cert.Fingerprint, cert.SubjectHash, cert.IssuerHash, cert.CommonName,
 		cert.Domains, timestampToSQLite(cert.NotAfter), timestampToSQLite(cert.NotBefore), cert.Fingerprint)
 
+	// the metadata is filled once, it also fills certificates stored by previous versions
+	sql = sql + fmt.Sprintf(`
+		UPDATE certs SET spki_hash='%s', der='%s', serial='%s', key_algorithm='%s', key_size=%d,
+			signature_algorithm='%s', key_usage='%s', ext_key_usage='%s', ocsp_servers='%s',
+			issuing_urls='%s', crl_points='%s', is_ca=%t, max_path_len=%d
+		WHERE fingerprint='%s' AND serial='';
+	`, cert.SPKIHash, hex.EncodeToString(cert.DER), cert.SerialNumber, cert.KeyAlgorithm, cert.KeySize,
+		cert.SignatureAlgorithm, cert.KeyUsage, cert.ExtKeyUsage, escapeSQL(cert.OCSPServers.String()),
+		escapeSQL(cert.IssuingURLs.String()), escapeSQL(cert.CRLDistributionPoints.String()), cert.IsCA,
+		cert.MaxPathLen, cert.Fingerprint)
+	for _, san := range cert.SANs {
+		sql = sql + fmt.Sprintf(`
+		INSERT OR IGNORE INTO cert_sans(fingerprint, type, value) VALUES ('%s', '%s', '%s');
+	`, cert.Fingerprint, san.Type, escapeSQL(san.Value))
+	}
+	for _, domain := range domainRows(cert.SANs) {
+		sql = sql + fmt.Sprintf(`
+		INSERT OR IGNORE INTO cert_domains(fingerprint, name, parent, wildcard) VALUES ('%s', '%s', '%s', %t);
+	`, cert.Fingerprint, escapeSQL(domain.Name), escapeSQL(domain.Parent), domain.Wildcard)
+	}
+
+	ch := dbw.SingleWrite(sql)
+
+	return <-ch
+}
+
+// SearchDomain returns the certificates which cover the name and the states serving them,
+// the wildcard name also finds the certificates with names under it
+func (dbw *dbwrapper) SearchDomain(name string) []DBDomainMatch {
+	var (
+		id      int
+		domain  string
+		match   string
+		stateID sql.NullInt64
+	)
+
+	name = normalizeDomain(name)
+	parent := parentDomain(name)
+	conditions := []string{
+		fmt.Sprintf("d.name='%s'", escapeSQL(name)),
+		fmt.Sprintf("(d.wildcard=1 AND d.parent='%s')", escapeSQL(parent)),
+	}
+	if strings.HasPrefix(name, "*.") {
+		conditions = append(conditions, fmt.Sprintf("(d.wildcard=0 AND d.parent='%s')", escapeSQL(parent)))
+	}
+	query := fmt.Sprintf(`
+		SELECT d.id, d.name,
+			CASE WHEN d.name='%s' THEN '%s' WHEN d.wildcard=1 THEN '%s' ELSE '%s' END,
+			c.id, %s, sc.state_id
+		FROM cert_domains AS d
+			INNER JOIN vCerts AS c ON c.fingerprint = d.fingerprint
+			LEFT JOIN state_certs AS sc ON sc.fingerprint = d.fingerprint
+		WHERE %s ORDER BY d.id, sc.state_id
+	`, escapeSQL(name), MatchExact, MatchWildcard, MatchCovered, qualifyColumns(certColumns, "c"),
+		strings.Join(conditions, " OR "))
+
+	result := make([]DBDomainMatch, 0, 1)
+	rows, err := dbw.Query(query)
+	if err != nil {
+		log.Println(err)
+		return result
+	}
+	defer rows.Close()
+
+	// the rows of the match are repeated for every state serving the certificate
+	lastID := 0
+	stateIDs := make([][]int64, 0, 1)
+	for rows.Next() {
+		var c DBCertRow
+		fields := append([]interface{}{&id, &domain, &match, &c.ID}, certFields(&c)...)
+		if err := rows.Scan(append(fields, &stateID)...); err != nil {
+			log.Println(err)
+			break
+		}
+		if id != lastID {
+			lastID = id
+			result = append(result, DBDomainMatch{Certificate: c, Match: match, Name: domain, States: make([]DBStateRow, 0)})
+			stateIDs = append(stateIDs, nil)
+		}
+		if stateID.Valid {
+			stateIDs[len(stateIDs)-1] = append(stateIDs[len(stateIDs)-1], stateID.Int64)
+		}
+	}
+	rows.Close()
+	if len(result) == 0 {
+		return result
+	}
+
+	certs := make([]DBCertRow, 0, len(result))
+	ids := make([]string, 0, 1)
+	for i := range result {
+		certs = append(certs, result[i].Certificate)
+		for _, id := range stateIDs[i] {
+			ids = append(ids, strconv.FormatInt(id, 10))
+		}
+	}
+	dbw.loadSANs(certs)
+	states := make(map[int64]DBStateRow)
+	if len(ids) != 0 {
+		for _, st := range dbw.GetStatesBy(fmt.Sprintf("WHERE id IN (%s)", strings.Join(ids, ","))) {
+			states[int64(st.ID)] = st
+		}
+	}
+	for i := range result {
+		result[i].Certificate = certs[i]
+		for _, id := range stateIDs[i] {
+			if st, ok := states[id]; ok {
+				result[i].States = append(result[i].States, st)
+			}
+		}
+	}
+	return result
+}
+
+func (dbw *dbwrapper) GetOCSPResponse(fingerprint string) *DBOCSPRow {
+	var r DBOCSPRow
+
+	sql := fmt.Sprintf(`
+		SELECT fingerprint, response, this_update, next_update
+		FROM ocsp_responses WHERE fingerprint='%s'
+	`, fingerprint)
+	if err := dbw.QueryRow(sql).Scan(&r.Fingerprint, &r.Response, &r.ThisUpdate, &r.NextUpdate); err != nil {
+		return nil
+	}
+	return &r
+}
+
+func (dbw *dbwrapper) InsertOCSPResponse(resp DBOCSPRow) error {
+	sql := fmt.Sprintf(`
+		INSERT OR REPLACE INTO ocsp_responses(
+			fingerprint, response, this_update, next_update
+		) VALUES ('%s', '%s', '%s', '%s');
+	`, resp.Fingerprint, resp.Response, timestampToSQLite(resp.ThisUpdate), timestampToSQLite(resp.NextUpdate))
+
+	ch := dbw.SingleWrite(sql)
+
+	return <-ch
+}
+
+func (dbw *dbwrapper) GetCRL(issuerHash string) *DBCRLRow {
+	var r DBCRLRow
+
+	sql := fmt.Sprintf(`
+		SELECT issuer_hash, url, crl, this_update, next_update
+		FROM crls WHERE issuer_hash='%s'
+	`, issuerHash)
+	if err := dbw.QueryRow(sql).Scan(&r.IssuerHash, &r.URL, &r.CRL, &r.ThisUpdate, &r.NextUpdate); err != nil {
+		return nil
+	}
+	return &r
+}
+
+func (dbw *dbwrapper) DeleteCRL(issuerHash string) error {
+	ch := dbw.SingleWrite(fmt.Sprintf(`DELETE FROM crls WHERE issuer_hash='%s';`, escapeSQL(issuerHash)))
+	return <-ch
+}
+
+func (dbw *dbwrapper) InsertCRL(crl DBCRLRow) error {
+	sql := fmt.Sprintf(`
+		INSERT OR REPLACE INTO crls(
+			issuer_hash, url, crl, this_update, next_update
+		) VALUES ('%s', '%s', '%s', '%s', '%s');
+	`, crl.IssuerHash, escapeSQL(crl.URL), crl.CRL, timestampToSQLite(crl.ThisUpdate), timestampToSQLite(crl.NextUpdate))
+
+	ch := dbw.SingleWrite(sql)
+
+	return <-ch
+}
+
+func splitList(list string) []string {
+	if len(list) == 0 {
+		return []string{}
+	}
+	return strings.Split(list, "\n")
+}
+
+func (dbw *dbwrapper) GetScanByStateID(id int) *DBScanRow {
+	var (
+		r                                DBScanRow
+		versions, cipherSuites, findings string
+	)
+
+	sql := fmt.Sprintf(`
+		SELECT state_id, ts, versions, cipher_suites, findings
+		FROM scans WHERE state_id=%d
+	`, id)
+	if err := dbw.QueryRow(sql).Scan(&r.StateID, &r.TS, &versions, &cipherSuites, &findings); err != nil {
+		return nil
+	}
+	r.Versions = splitList(versions)
+	r.CipherSuites = splitList(cipherSuites)
+	r.Findings = splitList(findings)
+	return &r
+}
+
+func (dbw *dbwrapper) InsertScan(scan DBScanRow) error {
+	sql := fmt.Sprintf(`
+		INSERT OR REPLACE INTO scans(
+			state_id, ts, versions, cipher_suites, findings
+		) VALUES (%d, '%s', '%s', '%s', '%s');
+	`, scan.StateID, timestampToSQLite(scan.TS), strings.Join(scan.Versions, "\n"),
+		strings.Join(scan.CipherSuites, "\n"), escapeSQL(strings.Join(scan.Findings, "\n")))
+
+	ch := dbw.SingleWrite(sql)
+
+	return <-ch
+}
+
+func (dbw *dbwrapper) GetPinByStateID(id int) *DBPinRow {
+	var (
+		r                                 DBPinRow
+		spkiHashes, issuerHashes, domains string
+	)
+
+	sql := fmt.Sprintf(`
+		SELECT state_id, spki_hashes, issuer_hashes, domains
+		FROM state_pins WHERE state_id=%d
+	`, id)
+	if err := dbw.QueryRow(sql).Scan(&r.StateID, &spkiHashes, &issuerHashes, &domains); err != nil {
+		return nil
+	}
+	r.SPKIHashes = splitList(spkiHashes)
+	r.IssuerHashes = splitList(issuerHashes)
+	r.Domains = splitList(domains)
+	return &r
+}
+
+func (dbw *dbwrapper) InsertPin(pin DBPinRow) error {
+	sql := fmt.Sprintf(`
+		INSERT OR REPLACE INTO state_pins(
+			state_id, spki_hashes, issuer_hashes, domains
+		) VALUES (%d, '%s', '%s', '%s');
+	`, pin.StateID, strings.Join(pin.SPKIHashes, "\n"), strings.Join(pin.IssuerHashes, "\n"),
+		escapeSQL(strings.Join(pin.Domains, "\n")))
+
 	ch := dbw.SingleWrite(sql)
 
 	return <-ch
 }
 
+func (dbw *dbwrapper) DeletePin(stateID int) error {
+	ch := dbw.SingleWrite(fmt.Sprintf(`DELETE FROM state_pins WHERE state_id=%d;`, stateID))
+	return <-ch
+}
+
 func (dbw *dbwrapper) InsertExclude(host string, sni string) error {
 	return nil
 }
@@ -383,9 +1319,9 @@ func (dbw *dbwrapper) InsertState(state DBStateRow) error {
 
 	sql := fmt.Sprintf(`
 		INSERT OR IGNORE INTO states(
-			host, sni, type 
-		) VALUES ('%s', '%s', %d);		
-		`, state.Host, state.SNI, state.Type)
+			host, sni, proto, type, scan 
+		) VALUES ('%s', '%s', '%s', %d, %t);		
+		`, state.Host, state.SNI, state.Protocol, state.Type, state.Scan)
 
 	ch := dbw.SingleWrite(sql)
 	if err := <-ch; err != nil {
@@ -398,17 +1334,139 @@ func (dbw *dbwrapper) InsertState(state DBStateRow) error {
 	return nil
 }
 
+// getServedFingerprints returns the chain found by the last successful check of the state,
+// it is read from the history since `state_certs` is emptied by failed checks
+func (dbw *dbwrapper) getServedFingerprints(stateID int) []string {
+	var fp string
+
+	sql := fmt.Sprintf(`
+		SELECT fingerprint FROM state_cert_history
+		WHERE state_id=%d AND last_seen=(SELECT MAX(last_seen) FROM state_cert_history WHERE state_id=%d)
+		ORDER BY position
+	`, stateID, stateID)
+	fingerprints := make([]string, 0, 1)
+	rows, err := dbw.Query(sql)
+	if err != nil {
+		log.Println(err)
+		return fingerprints
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&fp); err != nil {
+			log.Println(err)
+			break
+		}
+		fingerprints = append(fingerprints, fp)
+	}
+	return fingerprints
+}
+
+func (dbw *dbwrapper) GetTimelineByStateID(id int) *DBTimeline {
+	var (
+		h DBHistoryRow
+		e DBEventRow
+	)
+
+	timeline := &DBTimeline{
+		StateID:      id,
+		Certificates: make([]DBHistoryRow, 0, 1),
+		Events:       make([]DBEventRow, 0, 1),
+	}
+	sql := fmt.Sprintf(`
+		SELECT state_id, fingerprint, position, first_seen, last_seen
+		FROM state_cert_history WHERE state_id=%d ORDER BY first_seen, id
+	`, id)
+	rows, err := dbw.Query(sql)
+	if err != nil {
+		log.Println(err)
+		return timeline
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&h.StateID, &h.Fingerprint, &h.Position, &h.FirstSeen, &h.LastSeen); err != nil {
+			log.Println(err)
+			break
+		}
+		timeline.Certificates = append(timeline.Certificates, h)
+	}
+
+	sql = fmt.Sprintf(`
+		SELECT id, state_id, ts, type, fingerprint, previous, description
+		FROM state_events WHERE state_id=%d ORDER BY ts, id
+	`, id)
+	events, err := dbw.Query(sql)
+	if err != nil {
+		log.Println(err)
+		return timeline
+	}
+	defer events.Close()
+	for events.Next() {
+		if err := events.Scan(&e.ID, &e.StateID, &e.TS, &e.Type, &e.Fingerprint, &e.Previous, &e.Description); err != nil {
+			log.Println(err)
+			break
+		}
+		timeline.Events = append(timeline.Events, e)
+	}
+	return timeline
+}
+
 func (dbw *dbwrapper) UpdateState(state *DBStateRow) error {
+	if state.held {
+		return dbw.updateHeldState(state)
+	}
 	for _, cert := range state.Certificates {
 		if err := dbw.InsertCert(cert); err != nil {
 			return nil
 		}
 	}
 	state.TS = time.Now()
+	current := make([]string, 0, len(state.Certificates))
+	for _, cert := range state.Certificates {
+		current = append(current, cert.Fingerprint)
+	}
+	var events []DBEventRow
+	if state.ID != 0 {
+		events = rotationEvents(state.ID, dbw.getServedFingerprints(state.ID), current, state.TS)
+		logEvents(state.Host, state.SNI, events)
+	}
 	sql := fmt.Sprintf(`
-			UPDATE states SET valid=%d, description='%s', ts='%s'
+			UPDATE states SET valid=%d, description='%s', ts='%s', chain='%s', ocsp='%s', ocsp_stapled=%t,
+				tls_version='%s', cipher_suite='%s', alpn='%s', latency=%d, weak_tls=%t, inconsistent=%t,
+				error_category='%s', error='%s', alert=%d, failures=%d, flaps=%d, severity='%s', distrusted=%t,
+				dane='%s', http_status=%d, http_version='%s', hsts='%s', hsts_max_age=%d, hsts_subdomains=%t,
+				hsts_preload=%t, http_redirect='%s', pending=%d
 			WHERE host='%s' AND sni='%s';
-		`, state.Valid, state.Description, timestampToSQLite(state.TS), state.Host, state.SNI)
+		`, state.Valid, escapeSQL(state.Description), timestampToSQLite(state.TS), state.Chain, state.OCSP,
+		state.OCSPStapled, state.TLSVersion, state.CipherSuite, escapeSQL(state.ALPN), state.Latency,
+		state.WeakTLS, state.Inconsistent, state.ErrorCategory, escapeSQL(state.Error), state.Alert,
+		state.Failures, state.Flaps, state.Severity, state.Distrusted, state.DANE, state.HTTPStatus,
+		escapeSQL(state.HTTPVersion), escapeSQL(state.HSTS), state.HSTSMaxAge, state.HSTSSubdomains, state.HSTSPreload,
+		state.HTTPRedirect, state.Pending, state.Host, state.SNI)
+
+	sql = sql + fmt.Sprintf(`
+			DELETE FROM state_backends WHERE EXISTS (
+				SELECT 1 FROM states WHERE state_backends.state_id=states.id AND host='%s' AND sni='%s'
+			);
+	`, state.Host, state.SNI)
+	for _, backend := range state.Backends {
+		sql = sql + fmt.Sprintf(`
+			INSERT INTO state_backends(state_id, address, fingerprint, error, error_category) 
+			SELECT id, '%s', '%s', '%s', '%s' FROM states WHERE host='%s' AND sni='%s';
+		`, backend.Address, backend.Fingerprint, escapeSQL(backend.Error), backend.ErrorCategory,
+			state.Host, state.SNI)
+	}
+
+	sql = sql + fmt.Sprintf(`
+			DELETE FROM state_findings WHERE EXISTS (
+				SELECT 1 FROM states WHERE state_findings.state_id=states.id AND host='%s' AND sni='%s'
+			);
+	`, state.Host, state.SNI)
+	for _, finding := range state.Findings {
+		sql = sql + fmt.Sprintf(`
+			INSERT INTO state_findings(state_id, rule, severity, message)
+			SELECT id, '%s', '%s', '%s' FROM states WHERE host='%s' AND sni='%s';
+		`, finding.Rule, finding.Severity, escapeSQL(finding.Message), state.Host, state.SNI)
+	}
 
 	sql = sql + fmt.Sprintf(`
 			DELETE FROM state_certs WHERE EXISTS (
@@ -416,17 +1474,59 @@ func (dbw *dbwrapper) UpdateState(state *DBStateRow) error {
 			);
 	`, state.Host, state.SNI)
 
-	for _, cert := range state.Certificates {
+	for i, cert := range state.Certificates {
 		sql = sql + fmt.Sprintf(`
 			INSERT INTO state_certs(state_id, fingerprint) 
 			SELECT id, '%s' FROM states WHERE host='%s' AND sni='%s';
 		`, cert.Fingerprint, state.Host, state.SNI)
+		sql = sql + fmt.Sprintf(`
+			INSERT INTO state_cert_history(state_id, fingerprint, position, first_seen, last_seen)
+			SELECT id, '%s', %d, '%s', '%s' FROM states WHERE host='%s' AND sni='%s'
+			ON CONFLICT(state_id, fingerprint) DO UPDATE SET last_seen=excluded.last_seen, position=excluded.position;
+		`, cert.Fingerprint, i, timestampToSQLite(state.TS), timestampToSQLite(state.TS), state.Host, state.SNI)
+		sql = sql + fmt.Sprintf(`
+			DELETE FROM cert_findings WHERE fingerprint='%s';
+		`, cert.Fingerprint)
+		for _, finding := range cert.Findings {
+			sql = sql + fmt.Sprintf(`
+			INSERT INTO cert_findings(fingerprint, rule, severity, message) VALUES ('%s', '%s', '%s', '%s');
+		`, finding.Fingerprint, finding.Rule, finding.Severity, escapeSQL(finding.Message))
+		}
+		if len(cert.Class) != 0 {
+			sql = sql + fmt.Sprintf(`
+			UPDATE certs SET class='%s' WHERE fingerprint='%s';
+		`, cert.Class, cert.Fingerprint)
+		}
+		if cert.Revoked != CertRevocationUnknown {
+			sql = sql + fmt.Sprintf(`
+			UPDATE certs SET revoked=%d WHERE fingerprint='%s';
+		`, cert.Revoked, cert.Fingerprint)
+		}
+	}
+	for _, event := range events {
+		sql = sql + fmt.Sprintf(`
+			INSERT INTO state_events(state_id, ts, type, fingerprint, previous, description)
+			VALUES (%d, '%s', '%s', '%s', '%s', '%s');
+		`, event.StateID, timestampToSQLite(event.TS), event.Type, event.Fingerprint, event.Previous,
+			escapeSQL(event.Description))
 	}
 	ch := dbw.SingleWrite(sql)
 
 	return <-ch
 }
 
+// updateHeldState writes the error and the failures of the damped check,
+// the certificates and the results of the previous check are kept
+func (dbw *dbwrapper) updateHeldState(state *DBStateRow) error {
+	state.TS = time.Now()
+	sql := fmt.Sprintf(`
+		UPDATE states SET ts='%s', error_category='%s', error='%s', alert=%d, failures=%d, pending=%d
+		WHERE host='%s' AND sni='%s';
+	`, timestampToSQLite(state.TS), state.ErrorCategory, escapeSQL(state.Error), state.Alert, state.Failures,
+		state.Pending, state.Host, state.SNI)
+	return <-dbw.SingleWrite(sql)
+}
+
 func (dbw *dbwrapper) UpdateStateLastDiscovery(state *DBStateRow) error {
 	state.LastDiscovery = time.Now()
 	sql := fmt.Sprintf(`
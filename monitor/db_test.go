@@ -0,0 +1,63 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestSearchDomain(t *testing.T) {
+	mon := testMonitor(t)
+	year := time.Now().AddDate(1, 0, 0)
+	withNames := func(names ...string) func(*x509.Certificate) {
+		return func(c *x509.Certificate) { c.DNSNames = names }
+	}
+	wildcard, _ := testCert(t, "example.com", false, nil, nil, year, withNames("example.com", "*.example.com"))
+	www, _ := testCert(t, "www.example.com", false, nil, nil, year)
+	for _, cert := range []*x509.Certificate{wildcard, www} {
+		if err := mon.DB.InsertCert(newCertRow(cert)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, host := range []string{"example.com:443", "example.com:8443"} {
+		st := NewState(host, "example.com", "")
+		if err := mon.DB.InsertState(*st); err != nil {
+			t.Fatal(err)
+		}
+		st.Valid = ValidState
+		st.Certificates = []DBCertRow{newCertRow(wildcard)}
+		if err := mon.DB.UpdateState(st); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		matches []string
+		states  []int
+	}{
+		{"example.com", []string{MatchExact}, []int{2}},
+		{"EXAMPLE.com.", []string{MatchExact}, []int{2}},
+		{"api.example.com", []string{MatchWildcard}, []int{2}},
+		{"www.example.com", []string{MatchWildcard, MatchExact}, []int{2, 0}},
+		{"*.example.com", []string{MatchExact, MatchCovered}, []int{2, 0}},
+		{"a.b.example.com", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := mon.DB.SearchDomain(tt.name)
+			if len(matches) != len(tt.matches) {
+				t.Fatalf("SearchDomain() returns %d matches, want %d", len(matches), len(tt.matches))
+			}
+			for i, m := range matches {
+				if m.Match != tt.matches[i] || len(m.States) != tt.states[i] {
+					t.Errorf("match %d is %s with %d states, want %s with %d", i, m.Match, len(m.States),
+						tt.matches[i], tt.states[i])
+				}
+				if m.Certificate.ID == 0 || len(m.Certificate.SANs) == 0 {
+					t.Errorf("match %d has no certificate details", i)
+				}
+			}
+		})
+	}
+}
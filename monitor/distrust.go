@@ -0,0 +1,112 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// CertSelfSigned means that the certificate is self-signed and it is not a system root
+	CertSelfSigned = "self_signed"
+	// CertPrivateCA means that the certificate is issued by CA which is not trusted by the system
+	CertPrivateCA = "private"
+	// CertPublicCA means that the certificate chains to a system root
+	CertPublicCA = "public"
+)
+
+// CertClasses lists all certificate classes
+var CertClasses = []string{CertSelfSigned, CertPrivateCA, CertPublicCA}
+
+// ValidCertClass reports whether the certificate class is known
+func ValidCertClass(class string) bool {
+	for _, c := range CertClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyCert checks whether the certificate chains to a system root through the served certificates
+func classifyCert(cert *x509.Certificate, certs []*x509.Certificate, now time.Time) string {
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		if c != cert {
+			intermediates.AddCert(c)
+		}
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	switch {
+	case err == nil:
+		return CertPublicCA
+	case isSelfSigned(cert):
+		return CertSelfSigned
+	}
+	return CertPrivateCA
+}
+
+// trustPath returns the served certificates and the roots which complete the chain
+func trustPath(certs []*x509.Certificate, roots *x509.CertPool, now time.Time) []*x509.Certificate {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, _ := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	path := append([]*x509.Certificate{}, certs...)
+	for _, chain := range chains {
+		path = append(path, chain...)
+	}
+	return path
+}
+
+// matchDistrust reports whether the CA is issuer of the path certificates
+func matchDistrust(distrust *DistrustConfig, path []*x509.Certificate) bool {
+	for _, cert := range path {
+		if strings.EqualFold(distrust.SubjectHash, fingerprint(cert.RawIssuer)) {
+			return true
+		}
+		if !cert.IsCA {
+			continue
+		}
+		if strings.EqualFold(distrust.SubjectHash, fingerprint(cert.RawSubject)) ||
+			strings.EqualFold(distrust.SPKIHash, spkiHash(cert)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDistrust marks the state which chain passes through a distrusted CA
+func (mon Monitor) checkDistrust(st *DBStateRow, certs []*x509.Certificate, now time.Time) {
+	if len(certs) == 0 || len(mon.Cfg.Distrust) == 0 {
+		return
+	}
+	path := trustPath(certs, mon.Cfg.Roots(st.Host, st.SNI), now)
+	for i := range mon.Cfg.Distrust {
+		distrust := &mon.Cfg.Distrust[i]
+		if !distrust.since.IsZero() && !certs[0].NotBefore.After(distrust.since) {
+			continue
+		}
+		if !matchDistrust(distrust, path) {
+			continue
+		}
+		st.Distrusted = true
+		st.Valid = InvalidState
+		reason := distrust.Description
+		if len(reason) == 0 {
+			reason = distrust.SubjectHash + distrust.SPKIHash
+		}
+		st.Description = st.Description + fmt.Sprintf("\nChain passes through distrusted CA: %s", reason)
+	}
+}
@@ -0,0 +1,118 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDistrustSince(t *testing.T) {
+	tests := []struct {
+		name      string
+		distrust  string
+		wantSince time.Time
+		wantErr   bool
+	}{
+		{"absent", `{"subjectHash": "aa"}`, time.Time{}, false},
+		{"empty", `{"subjectHash": "aa", "since": ""}`, time.Time{}, false},
+		{"RFC 3339", `{"subjectHash": "aa", "since": "2024-11-12T00:00:00Z"}`,
+			time.Date(2024, 11, 12, 0, 0, 0, 0, time.UTC), false},
+		{"date only", `{"subjectHash": "aa", "since": "2024-11-12"}`, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "certmonitor.json")
+			if err := ioutil.WriteFile(filename, []byte(`{"distrust": [`+tt.distrust+`]}`), 0600); err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := loadConfig(filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if since := cfg.Distrust[0].since; !since.Equal(tt.wantSince) {
+				t.Errorf("since = %s, want %s", since, tt.wantSince)
+			}
+		})
+	}
+}
+
+func TestClassifyCert(t *testing.T) {
+	root, inter, leaf := testChain(t)
+	self, _ := testCert(t, "self.example.com", false, nil, nil, time.Now().AddDate(1, 0, 0))
+	// the public root is taken from the system bundle, the case is skipped without it
+	var public *x509.Certificate
+	if data, err := ioutil.ReadFile("/etc/ssl/certs/ca-certificates.crt"); err == nil {
+		if block, _ := pem.Decode(data); block != nil {
+			public, _ = x509.ParseCertificate(block.Bytes)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		cert  *x509.Certificate
+		certs []*x509.Certificate
+		want  string
+	}{
+		{"self-signed leaf", self, []*x509.Certificate{self}, CertSelfSigned},
+		{"private root", root, []*x509.Certificate{leaf, inter, root}, CertSelfSigned},
+		{"private intermediate", inter, []*x509.Certificate{leaf, inter, root}, CertPrivateCA},
+		{"private leaf", leaf, []*x509.Certificate{leaf, inter}, CertPrivateCA},
+		{"system root", public, []*x509.Certificate{public}, CertPublicCA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cert == nil {
+				t.Skip("system bundle is not found")
+			}
+			if got := classifyCert(tt.cert, tt.certs, time.Now()); got != tt.want {
+				t.Errorf("classifyCert() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDistrust(t *testing.T) {
+	root, inter, leaf := testChain(t)
+	trusted := x509.NewCertPool()
+	trusted.AddCert(root)
+	issued := leaf.NotBefore.UTC()
+
+	tests := []struct {
+		name     string
+		distrust DistrustConfig
+		want     bool
+	}{
+		{"intermediate subject", DistrustConfig{SubjectHash: fingerprint(inter.RawSubject)}, true},
+		{"root subject from trust store", DistrustConfig{SubjectHash: fingerprint(root.RawSubject)}, true},
+		{"intermediate SPKI", DistrustConfig{SPKIHash: spkiHash(inter)}, true},
+		{"leaf SPKI is not CA", DistrustConfig{SPKIHash: spkiHash(leaf)}, false},
+		{"other CA", DistrustConfig{SubjectHash: fingerprint(leaf.RawSubject)}, false},
+		{"issued after since", DistrustConfig{SPKIHash: spkiHash(inter),
+			Since: issued.Add(-time.Second).Format(time.RFC3339)}, true},
+		{"issued at since", DistrustConfig{SPKIHash: spkiHash(inter), Since: issued.Format(time.RFC3339)}, false},
+		{"issued before since", DistrustConfig{SPKIHash: spkiHash(inter),
+			Since: issued.Add(time.Hour).Format(time.RFC3339)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.distrust.parseSince(); err != nil {
+				t.Fatal(err)
+			}
+			mon := testMonitor(t)
+			mon.Cfg.TrustBundle = "test"
+			mon.Cfg.roots["test"] = trusted
+			mon.Cfg.Distrust = []DistrustConfig{tt.distrust}
+			st := &DBStateRow{Host: "leaf.example.com:443", SNI: "leaf.example.com", Valid: ValidState}
+			mon.checkDistrust(st, []*x509.Certificate{leaf, inter}, time.Now())
+			if st.Distrusted != tt.want || (st.Valid == InvalidState) != tt.want {
+				t.Errorf("distrusted %v valid %d, want %v", st.Distrusted, st.Valid, tt.want)
+			}
+		})
+	}
+}
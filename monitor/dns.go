@@ -2,8 +2,9 @@ package monitor
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"net"
+	"strconv"
 	"time"
 
 	"github.com/miekg/dns"
@@ -14,20 +15,27 @@ func (mon Monitor) discoveryZones() {
 		records := getZone(zone)
 		for _, v := range records {
 			hdr := v.Header()
-			if hdr.Rrtype == dns.TypeA {
+			if hdr.Rrtype == dns.TypeA || hdr.Rrtype == dns.TypeAAAA {
 				name := hdr.Name[:len(hdr.Name)-1]
 				mon.DB.InsertState(DBStateRow{
-					Host: name + ":443",
-					SNI:  name,
-					Type: DiscoveryState,
+					Host:     net.JoinHostPort(name, "443"),
+					SNI:      name,
+					Protocol: ProtoTLS,
+					Type:     DiscoveryState,
 				})
 			} else if !zone.OmitMX && hdr.Rrtype == dns.TypeMX {
 				mx := v.(*dns.MX)
 				name := mx.Mx[:len(mx.Mx)-1]
+				host := net.JoinHostPort(name, strconv.Itoa(zone.PortMX))
+				proto := zone.ProtoMX
+				if len(proto) == 0 {
+					proto = protocolByPort(host)
+				}
 				mon.DB.InsertState(DBStateRow{
-					Host: fmt.Sprintf("%s:%d", name, zone.PortMX),
-					SNI:  name,
-					Type: DiscoveryState,
+					Host:     host,
+					SNI:      name,
+					Protocol: proto,
+					Type:     DiscoveryState,
 				})
 			}
 		}
@@ -79,3 +87,43 @@ func getZone(zone ZoneConfig) (records []dns.RR) {
 	log.Printf("Transfer zone %s from %s is successfully\n", zone.Name, zone.Master)
 	return
 }
+
+// exchange sends the query to the server, the truncated UDP reply is requested again over TCP
+func exchange(msg *dns.Msg, server string, timeout time.Duration) (*dns.Msg, error) {
+	client := &dns.Client{Timeout: timeout}
+	reply, _, err := client.Exchange(msg, server)
+	if err == nil && reply.Truncated {
+		client.Net = "tcp"
+		reply, _, err = client.Exchange(msg, server)
+	}
+	return reply, err
+}
+
+// resolver returns DNS server for the name: the configured resolver,
+// the master of the zone or the system one
+func (cfg *Config) resolver(name string) string {
+	if len(cfg.Resolver) != 0 {
+		return cfg.Resolver
+	}
+	if zone := cfg.findZone(name); zone != nil && len(zone.Master) != 0 {
+		return zone.Master
+	}
+	return systemResolver()
+}
+
+// validatingResolver returns recursive DNS server which sets AD bit of DNSSEC validated replies:
+// the configured resolver or the system one, the zone master is authoritative and never sets it
+func (cfg *Config) validatingResolver() string {
+	if len(cfg.Resolver) != 0 {
+		return cfg.Resolver
+	}
+	return systemResolver()
+}
+
+// systemResolver returns the first DNS server of resolv.conf
+func systemResolver() string {
+	if conf, err := dns.ClientConfigFromFile("/etc/resolv.conf"); err == nil && len(conf.Servers) != 0 {
+		return net.JoinHostPort(conf.Servers[0], conf.Port)
+	}
+	return "127.0.0.1:53"
+}
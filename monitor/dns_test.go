@@ -0,0 +1,116 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testMaster starts DNS server which transfers the zone records framed by SOA
+func testMaster(t *testing.T, zone string, records ...string) string {
+	soa, _ := dns.NewRR(zone + " 60 IN SOA ns." + zone + " admin." + zone + " 1 60 60 60 60")
+	answer := []dns.RR{soa}
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		answer = append(answer, rr)
+	}
+	answer = append(answer, soa)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	srv := &dns.Server{
+		Listener:          l,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = answer
+			w.WriteMsg(m)
+		}),
+	}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+	return l.Addr().String()
+}
+
+func TestDiscoveryZones(t *testing.T) {
+	master := testMaster(t, "example.com.",
+		"a.example.com. 60 IN A 192.0.2.1",
+		"v6.example.com. 60 IN AAAA 2001:db8::1",
+		"example.com. 60 IN MX 10 mx.example.com.",
+		"example.com. 60 IN TXT \"v=spf1 -all\"",
+	)
+
+	tests := []struct {
+		name   string
+		omitMX bool
+		want   []string
+	}{
+		{"with MX", false, []string{"a.example.com:443 tls", "mx.example.com:25 smtp", "v6.example.com:443 tls"}},
+		{"omit MX", true, []string{"a.example.com:443 tls", "v6.example.com:443 tls"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mon := testMonitor(t)
+			mon.Cfg.Zones = []ZoneConfig{{Name: "example.com.", Master: master, OmitMX: tt.omitMX, PortMX: 25}}
+			mon.discoveryZones()
+			got := make([]string, 0, len(tt.want))
+			for _, st := range mon.DB.GetStatesBy("") {
+				if st.SNI != parseDomain(st.Host) || st.Type != DiscoveryState {
+					t.Errorf("state %s SNI %s type %d", st.Host, st.SNI, st.Type)
+				}
+				got = append(got, st.Host+" "+st.Protocol)
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("states %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("states %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestNewStateIPv6(t *testing.T) {
+	leaf, _ := testCert(t, "leaf.example.com", false, nil, nil, time.Now().Add(time.Hour),
+		func(c *x509.Certificate) { c.IPAddresses = []net.IP{net.ParseIP("2001:db8::1")} })
+
+	tests := []struct {
+		host      string
+		wantSNI   string
+		wantProto string
+		verified  bool
+	}{
+		{"leaf.example.com:443", "leaf.example.com", ProtoTLS, true},
+		{"[2001:db8::1]:443", "2001:db8::1", ProtoTLS, true},
+		{"[2001:db8::1]:25", "2001:db8::1", ProtoSMTP, true},
+		{"[2001:db8::2]:443", "2001:db8::2", ProtoTLS, false},
+		{"192.0.2.1:443", "192.0.2.1", ProtoTLS, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			st := NewState(tt.host, "", "")
+			if st.SNI != tt.wantSNI || st.Protocol != tt.wantProto {
+				t.Errorf("NewState() SNI %s proto %s, want %s %s", st.SNI, st.Protocol, tt.wantSNI, tt.wantProto)
+			}
+			if err := checkCertificateAt(leaf, st.SNI, time.Now()); (err == nil) != tt.verified {
+				t.Errorf("checkCertificateAt() error = %v, verified %v", err, tt.verified)
+			}
+		})
+	}
+}
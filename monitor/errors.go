@@ -0,0 +1,126 @@
+package monitor
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"syscall"
+)
+
+const (
+	// ErrorDNS means that the host name is not resolved
+	ErrorDNS = "dns"
+	// ErrorRefused means that TCP connection is refused
+	ErrorRefused = "tcp_refused"
+	// ErrorTimeout means that TCP connection is timed out
+	ErrorTimeout = "tcp_timeout"
+	// ErrorNetwork means another network failure, e.g. connection reset
+	ErrorNetwork = "network"
+	// ErrorHandshakeTimeout means that TLS negotiation or handshake is timed out
+	ErrorHandshakeTimeout = "handshake_timeout"
+	// ErrorTLSAlert means that the host replied with TLS alert
+	ErrorTLSAlert = "tls_alert"
+	// ErrorProtocol means that the host does not speak the expected protocol
+	ErrorProtocol = "protocol_mismatch"
+)
+
+// ErrorCategories lists all probe failure categories
+var ErrorCategories = []string{
+	ErrorDNS, ErrorRefused, ErrorTimeout, ErrorNetwork, ErrorHandshakeTimeout, ErrorTLSAlert, ErrorProtocol,
+}
+
+// ProbeError represents categorized failure of the probe
+//	Alert - TLS alert code, only for `ErrorTLSAlert`
+type ProbeError struct {
+	Category string
+	Alert    int
+	Err      error
+}
+
+func (e *ProbeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// ValidErrorCategory reports whether the category is known
+func ValidErrorCategory(category string) bool {
+	for _, c := range ErrorCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// retriable reports whether the probe failure may be transient,
+// TLS alerts and protocol mismatches are not retried
+func retriable(err error) bool {
+	var probeErr *ProbeError
+	if !errors.As(err, &probeErr) {
+		return true
+	}
+	return probeErr.Category != ErrorTLSAlert && probeErr.Category != ErrorProtocol
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// dialError categorizes failure of TCP connection
+func dialError(err error, msg string) *ProbeError {
+	category := ErrorNetwork
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		category = ErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		category = ErrorRefused
+	case isTimeout(err):
+		category = ErrorTimeout
+	}
+	return &ProbeError{Category: category, Err: fmt.Errorf("%s: %s", msg, err)}
+}
+
+// negotiationError categorizes failure of the protocol preamble
+func negotiationError(err error, msg string) *ProbeError {
+	category := ErrorProtocol
+	if isTimeout(err) {
+		category = ErrorHandshakeTimeout
+	}
+	return &ProbeError{Category: category, Err: fmt.Errorf("%s: %s", msg, err)}
+}
+
+// handshakeError categorizes failure of TLS handshake, the failures which are not caused
+// by the connection are protocol mismatches
+func handshakeError(err error, msg string) *ProbeError {
+	var (
+		alertErr tls.AlertError
+		opErr    *net.OpError
+		netErr   net.Error
+	)
+
+	e := &ProbeError{Category: ErrorProtocol, Err: fmt.Errorf("%s: %s", msg, err)}
+	switch {
+	case errors.As(err, &alertErr):
+		e.Category = ErrorTLSAlert
+		e.Alert = int(alertErr)
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		// the received alert is an unexported uint8 type of crypto/tls
+		e.Category = ErrorTLSAlert
+		if v := reflect.ValueOf(opErr.Err); v.Kind() == reflect.Uint8 {
+			e.Alert = int(v.Uint())
+		}
+	case isTimeout(err):
+		e.Category = ErrorHandshakeTimeout
+	case errors.As(err, &netErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		e.Category = ErrorNetwork
+	}
+	return e
+}
@@ -0,0 +1,39 @@
+package monitor
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestHandshakeError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		category string
+		alert    int
+	}{
+		{"alert", tls.AlertError(40), ErrorTLSAlert, 40},
+		{"timeout", &net.OpError{Op: "read", Err: timeoutError{}}, ErrorHandshakeTimeout, 0},
+		{"connection reset", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}, ErrorNetwork, 0},
+		{"eof", io.EOF, ErrorNetwork, 0},
+		{"record header", tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, ErrorProtocol, 0},
+		{"certificate", errors.New("tls: failed to parse certificate from server"), ErrorProtocol, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := handshakeError(tt.err, "handshake")
+			if e.Category != tt.category || e.Alert != tt.alert {
+				t.Errorf("handshakeError() = %s %d, want %s %d", e.Category, e.Alert, tt.category, tt.alert)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package monitor
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// FormatPEM is PEM encoded certificates
+	FormatPEM = "pem"
+	// FormatDER is DER encoded certificates, the chain is concatenated
+	FormatDER = "der"
+	// FormatPKCS7 is PKCS#7 certificates bundle
+	FormatPKCS7 = "p7b"
+	// FormatText is human-readable dump like `openssl x509 -text`
+	FormatText = "text"
+)
+
+var formatContentTypes = map[string]string{
+	FormatPEM:   "application/x-pem-file",
+	FormatDER:   "application/pkix-cert",
+	FormatPKCS7: "application/x-pkcs7-certificates",
+	FormatText:  "text/plain; charset=utf-8",
+}
+
+var (
+	oidPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// ValidFormat reports whether the certificates encoding is known
+func ValidFormat(format string) bool {
+	_, ok := formatContentTypes[format]
+	return ok
+}
+
+// FormatContentType returns MIME type of the certificates encoding
+func FormatContentType(format string) string {
+	return formatContentTypes[format]
+}
+
+// EncodeCertificates encodes DER certificates to the format
+func EncodeCertificates(ders [][]byte, format string) ([]byte, error) {
+	if len(ders) == 0 {
+		return nil, errors.New("No certificates")
+	}
+	buf := &bytes.Buffer{}
+	switch format {
+	case FormatPEM:
+		for _, der := range ders {
+			pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+		}
+	case FormatDER:
+		for _, der := range ders {
+			buf.Write(der)
+		}
+	case FormatPKCS7:
+		return encodePKCS7(ders)
+	case FormatText:
+		for _, der := range ders {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString(certText(cert))
+		}
+	default:
+		return nil, fmt.Errorf("Unknown format %s", format)
+	}
+	return buf.Bytes(), nil
+}
+
+// encodePKCS7 builds degenerate SignedData which contains only certificates
+func encodePKCS7(ders [][]byte) ([]byte, error) {
+	type contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"optional"`
+	}
+	type signedData struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      contentInfo
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: []byte{}}
+
+	signed, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      contentInfo{ContentType: oidPKCS7Data},
+		Certificates: asn1.RawValue{
+			Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(ders, nil),
+		},
+		SignerInfos: emptySet,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed},
+	})
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Non Repudiation"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "Any Extended Key Usage",
+	x509.ExtKeyUsageServerAuth:      "TLS Web Server Authentication",
+	x509.ExtKeyUsageClientAuth:      "TLS Web Client Authentication",
+	x509.ExtKeyUsageCodeSigning:     "Code Signing",
+	x509.ExtKeyUsageEmailProtection: "E-mail Protection",
+	x509.ExtKeyUsageTimeStamping:    "Time Stamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSP Signing",
+}
+
+// colonHex formats bytes like openssl does, e.g. 0a:1b:2c
+func colonHex(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func publicKeyText(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("rsaEncryption\n                Public-Key: (%d bit)\n                Exponent: %d",
+			key.N.BitLen(), key.E)
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("id-ecPublicKey\n                Public-Key: (%d bit)\n                NIST CURVE: %s",
+			key.Curve.Params().BitSize, key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "ED25519"
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
+
+// certText dumps the certificate in the human-readable form
+func certText(cert *x509.Certificate) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "Certificate:\n    Data:\n")
+	fmt.Fprintf(b, "        Version: %d (0x%x)\n", cert.Version, cert.Version-1)
+	fmt.Fprintf(b, "        Serial Number:\n            %s\n", colonHex(cert.SerialNumber.Bytes()))
+	fmt.Fprintf(b, "        Signature Algorithm: %s\n", cert.SignatureAlgorithm)
+	fmt.Fprintf(b, "        Issuer: %s\n", cert.Issuer)
+	fmt.Fprintf(b, "        Validity\n")
+	fmt.Fprintf(b, "            Not Before: %s\n", cert.NotBefore.UTC().Format("Jan _2 15:04:05 2006 GMT"))
+	fmt.Fprintf(b, "            Not After : %s\n", cert.NotAfter.UTC().Format("Jan _2 15:04:05 2006 GMT"))
+	fmt.Fprintf(b, "        Subject: %s\n", cert.Subject)
+	fmt.Fprintf(b, "        Subject Public Key Info:\n            Public Key Algorithm: %s\n", publicKeyText(cert))
+	fmt.Fprintf(b, "        X509v3 extensions:\n")
+
+	if cert.BasicConstraintsValid {
+		fmt.Fprintf(b, "            X509v3 Basic Constraints:\n                CA:%t", cert.IsCA)
+		if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
+			fmt.Fprintf(b, ", pathlen:%d", cert.MaxPathLen)
+		}
+		fmt.Fprintf(b, "\n")
+	}
+	if cert.KeyUsage != 0 {
+		fmt.Fprintf(b, "            X509v3 Key Usage:\n                %s\n", strings.Join(keyUsages(cert), ", "))
+	}
+	if usages := extKeyUsages(cert); len(usages) != 0 {
+		fmt.Fprintf(b, "            X509v3 Extended Key Usage:\n                %s\n", strings.Join(usages, ", "))
+	}
+	if len(cert.SubjectKeyId) != 0 {
+		fmt.Fprintf(b, "            X509v3 Subject Key Identifier:\n                %s\n", colonHex(cert.SubjectKeyId))
+	}
+	if len(cert.AuthorityKeyId) != 0 {
+		fmt.Fprintf(b, "            X509v3 Authority Key Identifier:\n                %s\n", colonHex(cert.AuthorityKeyId))
+	}
+	sans := make([]string, 0)
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP Address:"+ip.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	if len(sans) != 0 {
+		fmt.Fprintf(b, "            X509v3 Subject Alternative Name:\n                %s\n", strings.Join(sans, ", "))
+	}
+	if len(cert.CRLDistributionPoints) != 0 {
+		fmt.Fprintf(b, "            X509v3 CRL Distribution Points:\n")
+		for _, url := range cert.CRLDistributionPoints {
+			fmt.Fprintf(b, "                URI:%s\n", url)
+		}
+	}
+	if len(cert.OCSPServer) != 0 || len(cert.IssuingCertificateURL) != 0 {
+		fmt.Fprintf(b, "            Authority Information Access:\n")
+		for _, url := range cert.OCSPServer {
+			fmt.Fprintf(b, "                OCSP - URI:%s\n", url)
+		}
+		for _, url := range cert.IssuingCertificateURL {
+			fmt.Fprintf(b, "                CA Issuers - URI:%s\n", url)
+		}
+	}
+	if len(cert.PolicyIdentifiers) != 0 {
+		fmt.Fprintf(b, "            X509v3 Certificate Policies:\n")
+		for _, policy := range cert.PolicyIdentifiers {
+			fmt.Fprintf(b, "                Policy: %s\n", policy)
+		}
+	}
+	fmt.Fprintf(b, "    Signature Algorithm: %s\n", cert.SignatureAlgorithm)
+	fmt.Fprintf(b, "SHA1 Fingerprint=%s\n", strings.ToUpper(colonHex(sha1Sum(cert.Raw))))
+	fmt.Fprintf(b, "SHA256 Fingerprint=%s\n", strings.ToUpper(colonHex(sha256Sum(cert.Raw))))
+	pem.Encode(b, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	return b.String()
+}
+
+func sha1Sum(data []byte) []byte {
+	sum := sha1.Sum(data)
+	return sum[:]
+}
+
+func sha256Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
@@ -0,0 +1,167 @@
+package monitor
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exportChain returns the leaf and the intermediate which issued it
+func exportChain(t *testing.T) (*x509.Certificate, *x509.Certificate) {
+	issue := func(template *x509.Certificate, parent *x509.Certificate, key *ecdsa.PrivateKey,
+		parentKey *ecdsa.PrivateKey) *x509.Certificate {
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	interKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	inter := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Intermediate"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	inter = issue(inter, inter, interKey, interKey)
+	leaf := issue(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "leaf.example.com"},
+		DNSNames:     []string{"leaf.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, inter, leafKey, interKey)
+	return leaf, inter
+}
+
+// decodePKCS7 returns the certificates of degenerate SignedData
+func decodePKCS7(t *testing.T, data []byte) []*x509.Certificate {
+	var (
+		content struct {
+			ContentType asn1.ObjectIdentifier
+			Content     asn1.RawValue `asn1:"explicit,tag:0"`
+		}
+		signed struct {
+			Version          int
+			DigestAlgorithms asn1.RawValue
+			ContentInfo      asn1.RawValue
+			Certificates     asn1.RawValue `asn1:"tag:0"`
+			SignerInfos      asn1.RawValue
+		}
+	)
+	if _, err := asn1.Unmarshal(data, &content); err != nil {
+		t.Fatal(err)
+	}
+	if !content.ContentType.Equal(oidPKCS7SignedData) {
+		t.Fatalf("content type %s", content.ContentType)
+	}
+	if _, err := asn1.Unmarshal(content.Content.Bytes, &signed); err != nil {
+		t.Fatal(err)
+	}
+	certs, err := x509.ParseCertificates(signed.Certificates.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certs
+}
+
+func TestEncodeCertificates(t *testing.T) {
+	leaf, inter := exportChain(t)
+	ders := [][]byte{leaf.Raw, inter.Raw}
+
+	tests := []struct {
+		format string
+		decode func(t *testing.T, data []byte) []*x509.Certificate
+	}{
+		{FormatPEM, func(t *testing.T, data []byte) []*x509.Certificate {
+			certs := make([]*x509.Certificate, 0, 2)
+			for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+				cert, err := x509.ParseCertificate(block.Bytes)
+				if err != nil || block.Type != "CERTIFICATE" {
+					t.Fatalf("PEM block %s: %v", block.Type, err)
+				}
+				certs = append(certs, cert)
+			}
+			return certs
+		}},
+		{FormatDER, func(t *testing.T, data []byte) []*x509.Certificate {
+			certs, err := x509.ParseCertificates(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return certs
+		}},
+		{FormatPKCS7, decodePKCS7},
+		{FormatText, func(t *testing.T, data []byte) []*x509.Certificate {
+			text := string(data)
+			for _, want := range []string{"Subject: CN=leaf.example.com", "Subject: CN=Test Intermediate",
+				"DNS:leaf.example.com", "CA:true", "SHA256 Fingerprint="} {
+				if !strings.Contains(text, want) {
+					t.Errorf("text does not contain %q", want)
+				}
+			}
+			certs := make([]*x509.Certificate, 0, 2)
+			for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+				cert, _ := x509.ParseCertificate(block.Bytes)
+				certs = append(certs, cert)
+			}
+			return certs
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if !ValidFormat(tt.format) || len(FormatContentType(tt.format)) == 0 {
+				t.Fatalf("format %s is not valid", tt.format)
+			}
+			data, err := EncodeCertificates(ders, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			certs := tt.decode(t, data)
+			if len(certs) != len(ders) {
+				t.Fatalf("%d certificates are decoded, want %d", len(certs), len(ders))
+			}
+			for i, cert := range certs {
+				if !bytes.Equal(cert.Raw, ders[i]) {
+					t.Errorf("certificate %d differs", i)
+				}
+			}
+		})
+	}
+
+	failures := []struct {
+		name   string
+		ders   [][]byte
+		format string
+	}{
+		{"no certificates", nil, FormatPEM},
+		{"unknown format", ders, "pfx"},
+		{"malformed certificate", [][]byte{{0x30, 0x00}}, FormatText},
+	}
+	for _, tt := range failures {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := EncodeCertificates(tt.ders, tt.format); err == nil {
+				t.Error("EncodeCertificates() succeeds")
+			}
+		})
+	}
+}
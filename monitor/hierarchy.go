@@ -0,0 +1,156 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"sort"
+	"time"
+)
+
+// CANode represents issuer in the CA hierarchy, the node is identified by the subject hash
+//	Known - the issuer certificate is stored, roots are often not served by hosts
+//	Fingerprints - stored certificates of the issuer, e.g. renewed or cross-signed ones
+//	NotAfter - the earliest expiration of the issuer certificates
+//	Leaves - number of leaf certificates beneath the node
+//	States - number of states which chain through the node
+//	EarliestExpiry - the earliest expiration of the certificates beneath the node
+//	Severity - expiration severity of the issuer certificates, see `Severity*`
+type CANode struct {
+	Children       []*CANode `json:"children"`
+	CommonName     string    `json:"commonName"`
+	EarliestExpiry time.Time `json:"earliestExpiry"`
+	Fingerprints   []string  `json:"fingerprints"`
+	Known          bool      `json:"known"`
+	Leaves         int       `json:"leaves"`
+	NotAfter       time.Time `json:"notAfter"`
+	Severity       string    `json:"severity"`
+	States         int       `json:"states"`
+	SubjectHash    string    `json:"subjectHash"`
+
+	isChild bool
+}
+
+func earliest(a time.Time, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// issuerName returns the issuer name of the stored certificate
+func (mon Monitor) issuerName(id int) string {
+	for _, der := range mon.DB.GetCertificateDERByID(id) {
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			return certName(&x509.Certificate{Subject: cert.Issuer})
+		}
+	}
+	return ""
+}
+
+// CAHierarchy builds the issuers graph from subject and issuer hashes of the stored certificates,
+// it returns the roots
+func (mon Monitor) CAHierarchy() []*CANode {
+	certs := mon.DB.GetCertificatesBy("")
+	states := mon.DB.GetStateCountsByCertificate()
+	nodes := make(map[string]*CANode)
+
+	node := func(subjectHash string) *CANode {
+		if n, ok := nodes[subjectHash]; ok {
+			return n
+		}
+		n := &CANode{SubjectHash: subjectHash, Fingerprints: make([]string, 0, 1), Children: make([]*CANode, 0)}
+		nodes[subjectHash] = n
+		return n
+	}
+	for _, cert := range certs {
+		if !cert.IsCA {
+			continue
+		}
+		n := node(cert.SubjectHash)
+		n.Known = true
+		n.CommonName = cert.CommonName
+		n.Fingerprints = append(n.Fingerprints, cert.Fingerprint)
+		n.NotAfter = earliest(n.NotAfter, cert.NotAfter)
+	}
+
+	linked := make(map[[2]string]bool)
+	for _, cert := range certs {
+		issuer, exists := nodes[cert.IssuerHash]
+		if !exists {
+			issuer = node(cert.IssuerHash)
+			issuer.CommonName = mon.issuerName(cert.ID)
+		}
+		if !cert.IsCA {
+			issuer.Leaves++
+			issuer.States += states[cert.Fingerprint]
+			issuer.EarliestExpiry = earliest(issuer.EarliestExpiry, cert.NotAfter)
+			continue
+		}
+		link := [2]string{cert.IssuerHash, cert.SubjectHash}
+		child := nodes[cert.SubjectHash]
+		// cross-signed issuers may issue each other, the link closing the cycle is skipped
+		if linked[link] || reachable(child, issuer) {
+			continue
+		}
+		linked[link] = true
+		child.isChild = true
+		issuer.Children = append(issuer.Children, child)
+	}
+
+	warning, critical := mon.Cfg.WarningDays, mon.Cfg.CriticalDays
+	now := time.Now()
+	roots := make([]*CANode, 0, 1)
+	for _, n := range nodes {
+		if n.Known {
+			n.Severity = expirySeverity(n.NotAfter, warning, critical, now)
+		} else {
+			n.Severity = SeverityUnknown
+		}
+		if !n.isChild {
+			roots = append(roots, n)
+		}
+	}
+	done := make(map[*CANode]bool)
+	for _, root := range roots {
+		aggregateNode(root, done)
+	}
+	sortNodes(roots)
+	return roots
+}
+
+// reachable reports whether the target is the node or beneath it
+func reachable(n *CANode, target *CANode) bool {
+	if n == target {
+		return true
+	}
+	for _, child := range n.Children {
+		if reachable(child, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregateNode sums counts and the earliest expiration of the children into the node,
+// the node with several issuers is aggregated once
+func aggregateNode(n *CANode, done map[*CANode]bool) {
+	if done[n] {
+		return
+	}
+	for _, child := range n.Children {
+		aggregateNode(child, done)
+		n.Leaves += child.Leaves
+		n.States += child.States
+		n.EarliestExpiry = earliest(n.EarliestExpiry, child.NotAfter)
+		n.EarliestExpiry = earliest(n.EarliestExpiry, child.EarliestExpiry)
+	}
+	done[n] = true
+}
+
+func sortNodes(nodes []*CANode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].States > nodes[j].States
+	})
+	for _, n := range nodes {
+		sortNodes(n.Children)
+	}
+}
@@ -0,0 +1,90 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestCAHierarchy(t *testing.T) {
+	days := func(n int) time.Time { return time.Now().AddDate(0, 0, n).Truncate(time.Second) }
+	root, rootKey := testCert(t, "Test Root", true, nil, nil, days(3650))
+	inter, interKey := testCert(t, "Test Intermediate", true, root, rootKey, days(20))
+	other, otherKey := testCert(t, "Other CA", true, nil, nil, days(3650))
+	first, _ := testCert(t, "first.example.com", false, inter, interKey, days(30))
+	second, _ := testCert(t, "second.example.com", false, inter, interKey, days(60))
+	third, _ := testCert(t, "third.example.com", false, other, otherKey, days(90))
+
+	mon := testMonitor(t)
+	mon.Cfg.WarningDays, mon.Cfg.CriticalDays = 30, 10
+	// the other CA is not stored, its leaf is served by no state
+	for _, cert := range []*x509.Certificate{root, inter, first, second, third} {
+		if err := mon.DB.InsertCert(newCertRow(cert)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	served := map[string]*x509.Certificate{
+		"first.example.com:443":  first,
+		"first.example.com:8443": first,
+		"second.example.com:443": second,
+	}
+	for host, cert := range served {
+		st := NewState(host, "", "")
+		if err := mon.DB.InsertState(*st); err != nil {
+			t.Fatal(err)
+		}
+		st.Valid = ValidState
+		st.Certificates = []DBCertRow{newCertRow(cert), newCertRow(inter)}
+		if err := mon.DB.UpdateState(st); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	roots := mon.CAHierarchy()
+	nodes := make(map[string]*CANode)
+	var walk func(n *CANode)
+	walk = func(n *CANode) {
+		nodes[n.CommonName] = n
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	for _, n := range roots {
+		walk(n)
+	}
+	if len(roots) != 2 || roots[0].CommonName != "Test Root" {
+		t.Fatalf("%d roots, the first is %q, want Test Root of 2", len(roots), roots[0].CommonName)
+	}
+
+	tests := []struct {
+		name     string
+		known    bool
+		children int
+		leaves   int
+		states   int
+		earliest time.Time
+		severity string
+	}{
+		{"Test Root", true, 1, 2, 3, inter.NotAfter, SeverityOK},
+		{"Test Intermediate", true, 0, 2, 3, first.NotAfter, SeverityWarning},
+		{"Other CA", false, 0, 1, 0, third.NotAfter, SeverityUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, ok := nodes[tt.name]
+			if !ok {
+				t.Fatalf("node %s is missing", tt.name)
+			}
+			if n.Known != tt.known || len(n.Children) != tt.children || n.Severity != tt.severity {
+				t.Errorf("known %v children %d severity %s, want %v %d %s",
+					n.Known, len(n.Children), n.Severity, tt.known, tt.children, tt.severity)
+			}
+			if n.Leaves != tt.leaves || n.States != tt.states {
+				t.Errorf("leaves %d states %d, want %d %d", n.Leaves, n.States, tt.leaves, tt.states)
+			}
+			if !n.EarliestExpiry.Equal(tt.earliest) {
+				t.Errorf("earliest expiry %s, want %s", n.EarliestExpiry, tt.earliest)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package monitor
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// EventLeafChanged means that the host serves another leaf certificate
+	EventLeafChanged = "leaf_changed"
+	// EventChainChanged means that the host serves the same leaf certificate with another chain
+	EventChainChanged = "chain_changed"
+)
+
+// rotationEvents compares the chain served by the last successful check and the current one,
+// nothing is emitted if one of them is empty, e.g. the host is unreachable now
+func rotationEvents(stateID int, previous []string, current []string, ts time.Time) []DBEventRow {
+	if len(previous) == 0 || len(current) == 0 {
+		return nil
+	}
+	if previous[0] != current[0] {
+		return []DBEventRow{{
+			StateID:     stateID,
+			TS:          ts,
+			Type:        EventLeafChanged,
+			Fingerprint: current[0],
+			Previous:    previous[0],
+			Description: fmt.Sprintf("Leaf certificate is changed from %s to %s", previous[0], current[0]),
+		}}
+	}
+	if strings.Join(previous, ",") != strings.Join(current, ",") {
+		return []DBEventRow{{
+			StateID:     stateID,
+			TS:          ts,
+			Type:        EventChainChanged,
+			Fingerprint: current[0],
+			Previous:    previous[0],
+			Description: fmt.Sprintf("Chain is changed from [%s] to [%s]",
+				strings.Join(previous[1:], ", "), strings.Join(current[1:], ", ")),
+		}}
+	}
+	return nil
+}
+
+func logEvents(host string, sni string, events []DBEventRow) {
+	for _, event := range events {
+		log.Printf("%s/%s: %s\n", host, sni, event.Description)
+	}
+}
@@ -0,0 +1,44 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRotationEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous []string
+		current  []string
+		want     string
+	}{
+		{"first check", nil, []string{"leaf", "ca"}, ""},
+		{"unreachable", []string{"leaf", "ca"}, nil, ""},
+		{"same chain", []string{"leaf", "ca"}, []string{"leaf", "ca"}, ""},
+		{"leaf changed", []string{"leaf", "ca"}, []string{"leaf2", "ca"}, EventLeafChanged},
+		{"intermediate changed", []string{"leaf", "ca"}, []string{"leaf", "ca2"}, EventChainChanged},
+		{"intermediate added", []string{"leaf"}, []string{"leaf", "ca"}, EventChainChanged},
+	}
+	ts := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := rotationEvents(1, tt.previous, tt.current, ts)
+			if len(tt.want) == 0 {
+				if len(events) != 0 {
+					t.Fatalf("unexpected events %v", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want 1", len(events))
+			}
+			e := events[0]
+			if e.Type != tt.want || e.StateID != 1 || !e.TS.Equal(ts) {
+				t.Errorf("got %+v, want %s", e, tt.want)
+			}
+			if e.Fingerprint != tt.current[0] || e.Previous != tt.previous[0] {
+				t.Errorf("fingerprints %s <- %s", e.Fingerprint, e.Previous)
+			}
+		})
+	}
+}
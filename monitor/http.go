@@ -0,0 +1,207 @@
+package monitor
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	// RedirectHTTPS means that port 80 redirects to HTTPS
+	RedirectHTTPS = "https"
+	// RedirectOther means that port 80 redirects to plain HTTP
+	RedirectOther = "other"
+	// RedirectNone means that port 80 serves content without the redirect
+	RedirectNone = "none"
+	// RedirectClosed means that port 80 does not respond
+	RedirectClosed = "closed"
+
+	// FindingHSTS is the rule name of HSTS findings
+	FindingHSTS = "hsts"
+
+	defaultHSTSMinAge = 31536000
+	hstsHeader        = "Strict-Transport-Security"
+	httpUserAgent     = "certmonitor"
+	httpsPort         = "443"
+)
+
+// redirectPort is the plain HTTP port which is probed for the redirect to HTTPS
+var redirectPort = "80"
+
+// HTTPProbe represents the response to HTTP request over the established TLS connection
+//	Version - HTTP version of the response, HTTP/2.0 if h2 is negotiated by ALPN
+//	HSTS - Strict-Transport-Security header
+//	Error - request failure
+type HTTPProbe struct {
+	Error   string
+	HSTS    string
+	Status  int
+	Version string
+}
+
+// httpEnabled reports whether HTTPS hosts of the state are requested after the handshake
+func (mon Monitor) httpEnabled(st DBStateRow) bool {
+	if st.Protocol != ProtoTLS {
+		return false
+	}
+	if mon.Cfg.HTTP {
+		return true
+	}
+	if target := mon.Cfg.findTarget(st.Host, st.SNI); target != nil && target.HTTP {
+		return true
+	}
+	zone := mon.Cfg.findZone(st.SNI)
+	return zone != nil && zone.HTTP
+}
+
+// httpService reports whether the implicit TLS host serves HTTPS: it listens on port 443
+// or negotiates HTTP by ALPN, other services such as IMAPS or LDAPS are not requested
+func httpService(host string, alpn string) bool {
+	if alpn == "h2" || alpn == "http/1.1" {
+		return true
+	}
+	_, port, err := net.SplitHostPort(host)
+	return err == nil && port == httpsPort
+}
+
+// probeHTTP requests the root page over the connection, h2 is used if it is negotiated
+func (mon Monitor) probeHTTP(conn *tls.Conn, sni string) *HTTPProbe {
+	name := sni
+	if len(name) == 0 {
+		name, _, _ = net.SplitHostPort(conn.RemoteAddr().String())
+	}
+	authority := name
+	if strings.Contains(name, ":") {
+		authority = "[" + name + "]"
+	}
+	result := &HTTPProbe{}
+	req, err := http.NewRequest(http.MethodGet, "https://"+authority+"/", nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("User-Agent", httpUserAgent)
+	conn.SetDeadline(time.Now().Add(time.Duration(mon.Cfg.TLSTimeout) * time.Second))
+
+	var resp *http.Response
+	if conn.ConnectionState().NegotiatedProtocol == "h2" {
+		var cc *http2.ClientConn
+		if cc, err = (&http2.Transport{}).NewClientConn(conn); err == nil {
+			defer cc.Close()
+			resp, err = cc.RoundTrip(req)
+		}
+	} else {
+		req.Close = true
+		if err = req.Write(conn); err == nil {
+			resp, err = http.ReadResponse(bufio.NewReader(conn), req)
+		}
+	}
+	if err != nil {
+		result.Error = fmt.Sprintf("HTTP request to %s is failed: %s", name, err)
+		return result
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	result.Status = resp.StatusCode
+	result.Version = resp.Proto
+	result.HSTS = resp.Header.Get(hstsHeader)
+	return result
+}
+
+// parseHSTS returns the directives of Strict-Transport-Security header, max-age is -1 if it is missing
+func parseHSTS(header string) (maxAge int, subdomains bool, preload bool) {
+	maxAge = -1
+	for _, directive := range strings.Split(header, ";") {
+		parts := strings.SplitN(strings.TrimSpace(directive), "=", 2)
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case "max-age":
+			if len(parts) != 2 {
+				continue
+			}
+			if age, err := strconv.Atoi(strings.Trim(strings.TrimSpace(parts[1]), `"`)); err == nil && age >= 0 {
+				maxAge = age
+			}
+		case "includesubdomains":
+			subdomains = true
+		case "preload":
+			preload = true
+		}
+	}
+	return
+}
+
+// probeRedirect requests the host over plain HTTP and reports how it redirects to HTTPS
+func (mon Monitor) probeRedirect(name string) string {
+	client := &http.Client{
+		Timeout: time.Duration(mon.Cfg.TLSTimeout) * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://"+net.JoinHostPort(name, redirectPort)+"/", nil)
+	if err != nil {
+		return RedirectClosed
+	}
+	req.Header.Set("User-Agent", httpUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return RedirectClosed
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return RedirectNone
+	}
+	if location, err := resp.Location(); err == nil && location.Scheme == "https" {
+		return RedirectHTTPS
+	}
+	return RedirectOther
+}
+
+// checkHTTP stores the HTTP probe of the state and raises findings for missing or short HSTS,
+// the redirect from port 80 is probed even if the HTTPS request is failed
+func (mon Monitor) checkHTTP(st *DBStateRow, probe *HTTPProbe) {
+	name := st.SNI
+	if len(name) == 0 {
+		name, _, _ = net.SplitHostPort(st.Host)
+	}
+	st.HTTPRedirect = mon.probeRedirect(name)
+
+	if len(probe.Error) != 0 {
+		st.Description = st.Description + "\n" + probe.Error
+		return
+	}
+	st.HTTPStatus = probe.Status
+	st.HTTPVersion = probe.Version
+	st.HSTS = probe.HSTS
+	st.HSTSMaxAge, st.HSTSSubdomains, st.HSTSPreload = parseHSTS(probe.HSTS)
+
+	minAge := mon.Cfg.HSTSMinAge
+	if minAge == 0 {
+		minAge = defaultHSTSMinAge
+	}
+	finding := func(format string, args ...interface{}) {
+		st.Findings = append(st.Findings, DBStateFindingRow{
+			Message:  fmt.Sprintf(format, args...),
+			Rule:     FindingHSTS,
+			Severity: LintWarning,
+			StateID:  st.ID,
+		})
+	}
+	switch {
+	case len(probe.HSTS) == 0:
+		finding("HSTS header is missing")
+	case st.HSTSMaxAge < 0:
+		finding("HSTS header has no valid max-age: %s", probe.HSTS)
+	case st.HSTSMaxAge < minAge:
+		finding("HSTS max-age %d is shorter than %d seconds", st.HSTSMaxAge, minAge)
+	}
+}
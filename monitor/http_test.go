@@ -0,0 +1,79 @@
+package monitor
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHSTS(t *testing.T) {
+	tests := []struct {
+		header     string
+		maxAge     int
+		subdomains bool
+		preload    bool
+	}{
+		{"", -1, false, false},
+		{"max-age=31536000", 31536000, false, false},
+		{"max-age=63072000; includeSubDomains; preload", 63072000, true, true},
+		{`MAX-AGE="600" ; INCLUDESUBDOMAINS`, 600, true, false},
+		{"max-age=0", 0, false, false},
+		{"max-age=-1; preload", -1, false, true},
+		{"max-age=abc", -1, false, false},
+		{"max-age; includeSubDomains", -1, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			maxAge, subdomains, preload := parseHSTS(tt.header)
+			if maxAge != tt.maxAge || subdomains != tt.subdomains || preload != tt.preload {
+				t.Errorf("parseHSTS() = %d %v %v, want %d %v %v",
+					maxAge, subdomains, preload, tt.maxAge, tt.subdomains, tt.preload)
+			}
+		})
+	}
+}
+
+func TestHTTPService(t *testing.T) {
+	tests := []struct {
+		host string
+		alpn string
+		want bool
+	}{
+		{"example.com:443", "", true},
+		{"[2001:db8::1]:443", "", true},
+		{"example.com:8443", "h2", true},
+		{"example.com:8443", "http/1.1", true},
+		{"example.com:993", "", false},
+		{"example.com:636", "imap", false},
+		{"example.com", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host+" "+tt.alpn, func(t *testing.T) {
+			if got := httpService(tt.host, tt.alpn); got != tt.want {
+				t.Errorf("httpService() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckHTTPFailedProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://"+r.Host+"/", http.StatusMovedPermanently)
+	}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	defaultPort := redirectPort
+	redirectPort = port
+	defer func() { redirectPort = defaultPort }()
+
+	mon := testMonitor(t)
+	st := &DBStateRow{Host: "127.0.0.1:443", SNI: "127.0.0.1", Protocol: ProtoTLS}
+	mon.checkHTTP(st, &HTTPProbe{Error: "HTTP request to 127.0.0.1 is failed: EOF"})
+	if st.HTTPRedirect != RedirectHTTPS {
+		t.Errorf("redirect is %q, want %q", st.HTTPRedirect, RedirectHTTPS)
+	}
+	if st.HTTPStatus != 0 || len(st.Findings) != 0 {
+		t.Errorf("failed probe is stored: status %d, findings %v", st.HTTPStatus, st.Findings)
+	}
+}
@@ -0,0 +1,184 @@
+package monitor
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// LintNotice is severity of a finding which does not break clients
+	LintNotice = "notice"
+	// LintWarning is severity of a finding which violates CA/B Forum requirements
+	LintWarning = "warning"
+	// LintError is severity of a finding which makes the certificate unsafe
+	LintError = "error"
+
+	maxLeafValidity = 398 * 24 * time.Hour
+	minRSAKeySize   = 2048
+)
+
+// LintRule represents check of the certificate compliance
+//	Name - rule name used in the `lint` configuration section
+//	Severity - default severity of the rule findings
+//	Check - returns the finding message, empty if the certificate complies
+type LintRule struct {
+	Name     string
+	Severity string
+	Check    func(cert *x509.Certificate) string
+}
+
+// LintRules lists all lint rules
+var LintRules = []LintRule{
+	{"weak_key", LintError, lintWeakKey},
+	{"weak_signature", LintError, lintWeakSignature},
+	{"long_validity", LintWarning, lintLongValidity},
+	{"missing_san", LintError, lintMissingSAN},
+	{"cn_not_in_san", LintWarning, lintCNNotInSAN},
+	{"missing_server_auth", LintWarning, lintMissingServerAuth},
+	{"deprecated_key_usage", LintNotice, lintDeprecatedKeyUsage},
+}
+
+// ValidLintSeverity reports whether the finding severity is known
+func ValidLintSeverity(severity string) bool {
+	return severity == LintNotice || severity == LintWarning || severity == LintError
+}
+
+// LintCertificate runs the enabled rules against the certificate
+func (cfg *Config) LintCertificate(cert *x509.Certificate) []DBCertFindingRow {
+	findings := make([]DBCertFindingRow, 0)
+	for _, rule := range LintRules {
+		severity := rule.Severity
+		if ruleCfg, ok := cfg.Lint[rule.Name]; ok {
+			if ruleCfg.Disabled {
+				continue
+			}
+			if len(ruleCfg.Severity) != 0 {
+				severity = ruleCfg.Severity
+			}
+		}
+		if msg := rule.Check(cert); len(msg) != 0 {
+			findings = append(findings, DBCertFindingRow{
+				Fingerprint: fingerprint(cert.Raw),
+				Rule:        rule.Name,
+				Severity:    severity,
+				Message:     msg,
+			})
+		}
+	}
+	return findings
+}
+
+// isLeaf reports whether the certificate is the end-entity one
+func isLeaf(cert *x509.Certificate) bool {
+	return !cert.IsCA
+}
+
+func lintWeakKey(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if size := key.N.BitLen(); size < minRSAKeySize {
+			return fmt.Sprintf("RSA key size %d is less than %d", size, minRSAKeySize)
+		}
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return fmt.Sprintf("EC curve %s is not allowed", key.Curve.Params().Name)
+		}
+	case *dsa.PublicKey:
+		return "DSA keys are not allowed"
+	}
+	return ""
+}
+
+func lintWeakSignature(cert *x509.Certificate) string {
+	// signatures of self-signed roots are not verified by clients
+	if isSelfSigned(cert) {
+		return ""
+	}
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return fmt.Sprintf("Signature algorithm %s is weak", cert.SignatureAlgorithm)
+	}
+	return ""
+}
+
+func lintLongValidity(cert *x509.Certificate) string {
+	if !isLeaf(cert) {
+		return ""
+	}
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity > maxLeafValidity {
+		return fmt.Sprintf("Validity period %d days is longer than %d days",
+			int(validity.Hours()/24), int(maxLeafValidity.Hours()/24))
+	}
+	return ""
+}
+
+func lintMissingSAN(cert *x509.Certificate) string {
+	if isLeaf(cert) && len(cert.DNSNames) == 0 && len(cert.IPAddresses) == 0 {
+		return "Subject alternative names are missing"
+	}
+	return ""
+}
+
+func lintCNNotInSAN(cert *x509.Certificate) string {
+	cn := cert.Subject.CommonName
+	if !isLeaf(cert) || len(cn) == 0 {
+		return ""
+	}
+	for _, name := range cert.DNSNames {
+		if strings.EqualFold(name, cn) {
+			return ""
+		}
+	}
+	if ip := net.ParseIP(cn); ip != nil {
+		for _, addr := range cert.IPAddresses {
+			if addr.Equal(ip) {
+				return ""
+			}
+		}
+	}
+	return fmt.Sprintf("Common name %s is not listed in subject alternative names", cn)
+}
+
+func lintMissingServerAuth(cert *x509.Certificate) string {
+	if !isLeaf(cert) {
+		return ""
+	}
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth {
+			return ""
+		}
+	}
+	return "Extended key usage serverAuth is missing"
+}
+
+func lintDeprecatedKeyUsage(cert *x509.Certificate) string {
+	if !isLeaf(cert) {
+		return ""
+	}
+	usages := make([]string, 0)
+	if cert.KeyUsage&x509.KeyUsageDataEncipherment != 0 {
+		usages = append(usages, "dataEncipherment")
+	}
+	if cert.KeyUsage&x509.KeyUsageContentCommitment != 0 {
+		usages = append(usages, "contentCommitment")
+	}
+	if cert.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+		usages = append(usages, "keyCertSign/cRLSign")
+	}
+	if _, ok := cert.PublicKey.(*ecdsa.PublicKey); ok && cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+		usages = append(usages, "keyEncipherment with EC key")
+	}
+	if len(usages) == 0 {
+		return ""
+	}
+	return "Deprecated key usages " + strings.Join(usages, ", ")
+}
@@ -0,0 +1,130 @@
+package monitor
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLintRules(t *testing.T) {
+	rsaWeak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p224, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root, _ := testCert(t, "Root", true, nil, nil, time.Now().Add(time.Hour),
+		func(c *x509.Certificate) { c.SignatureAlgorithm = x509.ECDSAWithSHA1 })
+	now := time.Now()
+	leaf := func(options ...func(*x509.Certificate)) *x509.Certificate {
+		cert := &x509.Certificate{
+			Subject:            pkix.Name{CommonName: "leaf.example.com"},
+			RawSubject:         []byte("leaf"),
+			RawIssuer:          []byte("issuer"),
+			NotBefore:          now,
+			NotAfter:           now.AddDate(0, 0, 90),
+			PublicKey:          &p256.PublicKey,
+			SignatureAlgorithm: x509.ECDSAWithSHA256,
+			DNSNames:           []string{"leaf.example.com"},
+			KeyUsage:           x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		for _, option := range options {
+			option(cert)
+		}
+		return cert
+	}
+	ca := func(c *x509.Certificate) { c.IsCA = true }
+
+	tests := []struct {
+		name  string
+		check func(*x509.Certificate) string
+		cert  *x509.Certificate
+		want  bool
+	}{
+		{"weak_key compliant", lintWeakKey, leaf(), false},
+		{"weak_key RSA 1024", lintWeakKey, leaf(func(c *x509.Certificate) { c.PublicKey = &rsaWeak.PublicKey }), true},
+		{"weak_key P-224", lintWeakKey, leaf(func(c *x509.Certificate) { c.PublicKey = &p224.PublicKey }), true},
+		{"weak_key DSA", lintWeakKey, leaf(func(c *x509.Certificate) { c.PublicKey = &dsa.PublicKey{} }), true},
+		{"weak_signature compliant", lintWeakSignature, leaf(), false},
+		{"weak_signature SHA-1", lintWeakSignature, leaf(func(c *x509.Certificate) { c.SignatureAlgorithm = x509.SHA1WithRSA }), true},
+		{"weak_signature self-signed root", lintWeakSignature, root, false},
+		{"long_validity compliant", lintLongValidity, leaf(), false},
+		{"long_validity 2 years", lintLongValidity, leaf(func(c *x509.Certificate) { c.NotAfter = now.AddDate(2, 0, 0) }), true},
+		{"long_validity CA", lintLongValidity, leaf(ca, func(c *x509.Certificate) { c.NotAfter = now.AddDate(10, 0, 0) }), false},
+		{"missing_san compliant", lintMissingSAN, leaf(), false},
+		{"missing_san IP only", lintMissingSAN, leaf(func(c *x509.Certificate) {
+			c.DNSNames, c.IPAddresses = nil, []net.IP{net.ParseIP("192.0.2.1")}
+		}), false},
+		{"missing_san none", lintMissingSAN, leaf(func(c *x509.Certificate) { c.DNSNames = nil }), true},
+		{"missing_san CA", lintMissingSAN, leaf(ca, func(c *x509.Certificate) { c.DNSNames = nil }), false},
+		{"cn_not_in_san compliant", lintCNNotInSAN, leaf(), false},
+		{"cn_not_in_san case", lintCNNotInSAN, leaf(func(c *x509.Certificate) { c.DNSNames = []string{"LEAF.example.com"} }), false},
+		{"cn_not_in_san IP", lintCNNotInSAN, leaf(func(c *x509.Certificate) {
+			c.Subject.CommonName, c.IPAddresses = "2001:db8::1", []net.IP{net.ParseIP("2001:db8::1")}
+		}), false},
+		{"cn_not_in_san other", lintCNNotInSAN, leaf(func(c *x509.Certificate) { c.DNSNames = []string{"www.example.com"} }), true},
+		{"cn_not_in_san empty CN", lintCNNotInSAN, leaf(func(c *x509.Certificate) { c.Subject.CommonName = "" }), false},
+		{"missing_server_auth compliant", lintMissingServerAuth, leaf(), false},
+		{"missing_server_auth client only", lintMissingServerAuth, leaf(func(c *x509.Certificate) {
+			c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		}), true},
+		{"missing_server_auth CA", lintMissingServerAuth, leaf(ca, func(c *x509.Certificate) { c.ExtKeyUsage = nil }), false},
+		{"deprecated_key_usage compliant", lintDeprecatedKeyUsage, leaf(), false},
+		{"deprecated_key_usage data encipherment", lintDeprecatedKeyUsage, leaf(func(c *x509.Certificate) {
+			c.KeyUsage |= x509.KeyUsageDataEncipherment
+		}), true},
+		{"deprecated_key_usage EC key encipherment", lintDeprecatedKeyUsage, leaf(func(c *x509.Certificate) {
+			c.KeyUsage |= x509.KeyUsageKeyEncipherment
+		}), true},
+		{"deprecated_key_usage RSA key encipherment", lintDeprecatedKeyUsage, leaf(func(c *x509.Certificate) {
+			c.PublicKey, c.KeyUsage = &rsaWeak.PublicKey, c.KeyUsage|x509.KeyUsageKeyEncipherment
+		}), false},
+		{"deprecated_key_usage CA", lintDeprecatedKeyUsage, leaf(ca, func(c *x509.Certificate) { c.KeyUsage |= x509.KeyUsageCertSign }), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg := tt.check(tt.cert); (len(msg) != 0) != tt.want {
+				t.Errorf("finding %q, want %v", msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestLintCertificate(t *testing.T) {
+	cert, _ := testCert(t, "leaf.example.com", false, nil, nil, time.Now().AddDate(2, 0, 0),
+		func(c *x509.Certificate) { c.DNSNames = []string{"www.example.com"} })
+
+	tests := []struct {
+		name string
+		lint map[string]LintRuleConfig
+		want map[string]string
+	}{
+		{"defaults", nil, map[string]string{"long_validity": LintWarning, "cn_not_in_san": LintWarning}},
+		{"disabled", map[string]LintRuleConfig{"long_validity": {Disabled: true}},
+			map[string]string{"cn_not_in_san": LintWarning}},
+		{"severity", map[string]LintRuleConfig{"cn_not_in_san": {Severity: LintNotice}},
+			map[string]string{"long_validity": LintWarning, "cn_not_in_san": LintNotice}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Lint: tt.lint}
+			findings := cfg.LintCertificate(cert)
+			if len(findings) != len(tt.want) {
+				t.Fatalf("findings %v, want %v", findings, tt.want)
+			}
+			for _, finding := range findings {
+				if finding.Severity != tt.want[finding.Rule] || finding.Fingerprint != fingerprint(cert.Raw) {
+					t.Errorf("finding %s severity %s, want %s", finding.Rule, finding.Severity, tt.want[finding.Rule])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package monitor
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// SANDNS is DNS name in subject alternative names
+	SANDNS = "dns"
+	// SANIP is IP address in subject alternative names
+	SANIP = "ip"
+	// SANEmail is email address in subject alternative names
+	SANEmail = "email"
+	// SANURI is URI in subject alternative names
+	SANURI = "uri"
+)
+
+// keySize returns size of the certificate public key in bits
+func keySize(cert *x509.Certificate) int {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 8 * ed25519.PublicKeySize
+	}
+	return 0
+}
+
+func keyUsages(cert *x509.Certificate) StringList {
+	usages := make(StringList, 0)
+	for _, ku := range keyUsageNames {
+		if cert.KeyUsage&ku.usage != 0 {
+			usages = append(usages, ku.name)
+		}
+	}
+	return usages
+}
+
+func extKeyUsages(cert *x509.Certificate) StringList {
+	usages := make(StringList, 0)
+	for _, eku := range cert.ExtKeyUsage {
+		if name, ok := extKeyUsageNames[eku]; ok {
+			usages = append(usages, name)
+		} else {
+			usages = append(usages, fmt.Sprintf("%d", eku))
+		}
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		usages = append(usages, oid.String())
+	}
+	return usages
+}
+
+// sanRows lists all subject alternative names of the certificate
+func sanRows(cert *x509.Certificate) []DBSANRow {
+	fp := fingerprint(cert.Raw)
+	sans := make([]DBSANRow, 0, len(cert.DNSNames))
+	for _, name := range cert.DNSNames {
+		sans = append(sans, DBSANRow{Fingerprint: fp, Type: SANDNS, Value: strings.ToLower(name)})
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, DBSANRow{Fingerprint: fp, Type: SANIP, Value: ip.String()})
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, DBSANRow{Fingerprint: fp, Type: SANEmail, Value: email})
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, DBSANRow{Fingerprint: fp, Type: SANURI, Value: uri.String()})
+	}
+	return sans
+}
+
+// newCertRow fills the `certs` row with the certificate metadata
+func newCertRow(cert *x509.Certificate) DBCertRow {
+	maxPathLen := -1
+	if cert.BasicConstraintsValid && (cert.MaxPathLen > 0 || cert.MaxPathLenZero) {
+		maxPathLen = cert.MaxPathLen
+	}
+	return DBCertRow{
+		CommonName:            strings.ReplaceAll(cert.Subject.CommonName, "'", "''"),
+		NotAfter:              cert.NotAfter,
+		NotBefore:             cert.NotBefore,
+		Domains:               fmt.Sprintf("%s", cert.DNSNames),
+		Fingerprint:           fingerprint(cert.Raw),
+		SubjectHash:           fingerprint(cert.RawSubject),
+		IssuerHash:            fingerprint(cert.RawIssuer),
+		SPKIHash:              spkiHash(cert),
+		DER:                   cert.Raw,
+		Expired:               int(cert.NotAfter.Sub(time.Now()).Seconds()),
+		Revoked:               CertRevocationUnknown,
+		SerialNumber:          fmt.Sprintf("%x", cert.SerialNumber),
+		KeyAlgorithm:          cert.PublicKeyAlgorithm.String(),
+		KeySize:               keySize(cert),
+		SignatureAlgorithm:    cert.SignatureAlgorithm.String(),
+		KeyUsage:              keyUsages(cert),
+		ExtKeyUsage:           extKeyUsages(cert),
+		OCSPServers:           cert.OCSPServer,
+		IssuingURLs:           cert.IssuingCertificateURL,
+		CRLDistributionPoints: cert.CRLDistributionPoints,
+		IsCA:                  cert.BasicConstraintsValid && cert.IsCA,
+		MaxPathLen:            maxPathLen,
+		SANs:                  sanRows(cert),
+	}
+}
@@ -0,0 +1,120 @@
+package monitor
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeySize(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	edKey, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name string
+		key  interface{}
+		want int
+	}{
+		{"RSA", &rsaKey.PublicKey, 2048},
+		{"P-384", &p384.PublicKey, 384},
+		{"Ed25519", edKey, 256},
+		{"unknown", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keySize(&x509.Certificate{PublicKey: tt.key}); got != tt.want {
+				t.Errorf("keySize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCertRow(t *testing.T) {
+	year := time.Now().AddDate(1, 0, 0)
+	root, rootKey := testCert(t, "Test Root", true, nil, nil, year)
+	uri, _ := url.Parse("spiffe://example.com/leaf")
+	leaf, _ := testCert(t, "Leaf.example.com", false, root, rootKey, year, func(c *x509.Certificate) {
+		c.DNSNames = []string{"Leaf.example.com", "www.example.com"}
+		c.IPAddresses = []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")}
+		c.EmailAddresses = []string{"admin@example.com"}
+		c.URIs = []*url.URL{uri}
+		c.KeyUsage |= x509.KeyUsageKeyEncipherment
+		c.ExtKeyUsage = append(c.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+		c.UnknownExtKeyUsage = []asn1.ObjectIdentifier{{1, 2, 3, 4}}
+		c.OCSPServer = []string{"http://ocsp.example.com"}
+		c.CRLDistributionPoints = []string{"http://crl.example.com/ca.crl"}
+	})
+	pathLen := func(maxPathLen int, zero bool) func(*x509.Certificate) {
+		return func(c *x509.Certificate) { c.MaxPathLen, c.MaxPathLenZero = maxPathLen, zero }
+	}
+	unlimited, _ := testCert(t, "Unlimited CA", true, root, rootKey, year, pathLen(-1, false))
+	zero, _ := testCert(t, "Zero CA", true, root, rootKey, year, pathLen(0, true))
+	two, _ := testCert(t, "Two CA", true, root, rootKey, year, pathLen(2, false))
+
+	tests := []struct {
+		name       string
+		cert       *x509.Certificate
+		isCA       bool
+		maxPathLen int
+		keyUsage   StringList
+		extUsage   StringList
+		sans       []DBSANRow
+	}{
+		{"leaf", leaf, false, -1,
+			StringList{"Digital Signature", "Key Encipherment"},
+			StringList{"TLS Web Server Authentication", "TLS Web Client Authentication", "1.2.3.4"},
+			[]DBSANRow{
+				{Type: SANDNS, Value: "leaf.example.com"},
+				{Type: SANDNS, Value: "www.example.com"},
+				{Type: SANIP, Value: "192.0.2.1"},
+				{Type: SANIP, Value: "2001:db8::1"},
+				{Type: SANEmail, Value: "admin@example.com"},
+				{Type: SANURI, Value: "spiffe://example.com/leaf"},
+			}},
+		{"unlimited path length", unlimited, true, -1,
+			StringList{"Digital Signature", "Certificate Sign", "CRL Sign"}, StringList{"TLS Web Server Authentication"}, nil},
+		{"zero path length", zero, true, 0,
+			StringList{"Digital Signature", "Certificate Sign", "CRL Sign"}, StringList{"TLS Web Server Authentication"}, nil},
+		{"path length 2", two, true, 2,
+			StringList{"Digital Signature", "Certificate Sign", "CRL Sign"}, StringList{"TLS Web Server Authentication"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := newCertRow(tt.cert)
+			if row.IsCA != tt.isCA || row.MaxPathLen != tt.maxPathLen {
+				t.Errorf("CA %v max path length %d, want %v %d", row.IsCA, row.MaxPathLen, tt.isCA, tt.maxPathLen)
+			}
+			if row.Fingerprint != fingerprint(tt.cert.Raw) || row.IssuerHash != fingerprint(root.RawSubject) ||
+				row.SPKIHash != spkiHash(tt.cert) || row.KeySize != 256 || row.KeyAlgorithm != "ECDSA" {
+				t.Errorf("hashes or key are wrong: %+v", row)
+			}
+			if row.Revoked != CertRevocationUnknown {
+				t.Errorf("revoked %d, want unknown", row.Revoked)
+			}
+			if !reflect.DeepEqual(row.KeyUsage, tt.keyUsage) || !reflect.DeepEqual(row.ExtKeyUsage, tt.extUsage) {
+				t.Errorf("key usages %v %v, want %v %v", row.KeyUsage, row.ExtKeyUsage, tt.keyUsage, tt.extUsage)
+			}
+			if len(row.SANs) != len(tt.sans) {
+				t.Fatalf("SANs %v, want %v", row.SANs, tt.sans)
+			}
+			for i, san := range row.SANs {
+				if san.Fingerprint != row.Fingerprint || san.Type != tt.sans[i].Type || san.Value != tt.sans[i].Value {
+					t.Errorf("SAN %s %s, want %s %s", san.Type, san.Value, tt.sans[i].Type, tt.sans[i].Value)
+				}
+			}
+		})
+	}
+}
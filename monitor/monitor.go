@@ -2,8 +2,11 @@ package monitor
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path"
 	"regexp"
@@ -22,12 +25,14 @@ type Monitor struct {
 	Ctx        context.Context
 	DB         DBWrapper
 	stop       chan interface{}
+	tlsa       *tlsaCache
 }
 
 func NewMonitor() *Monitor {
 	return &Monitor{
 		Ctx:  context.Background(),
 		stop: make(chan interface{}),
+		tlsa: newTLSACache(),
 	}
 }
 
@@ -53,6 +58,7 @@ func (mon *Monitor) Run() {
 	mon.DB.RunWriter(ctxWithCancel)
 	mon.FetchDNS(ctxWithCancel)
 	mon.RunWatcher(ctxWithCancel)
+	mon.RunScanner(ctxWithCancel)
 }
 
 func (mon *Monitor) Stop() {
@@ -83,6 +89,7 @@ func (mon *Monitor) RunWatcher(ctx context.Context) {
 		}
 		for {
 			mon.MaintainDB()
+			mon.LoadCRLDirs()
 			states := mon.DB.GetStatesBy("")
 			for _, state := range states {
 				jobs <- state
@@ -97,53 +104,167 @@ func (mon *Monitor) RunWatcher(ctx context.Context) {
 	}()
 }
 
-func NewState(host string, sni string) *DBStateRow {
+func NewState(host string, sni string, proto string) *DBStateRow {
 	if len(sni) == 0 {
 		sni = parseDomain(host)
 	}
+	if len(proto) == 0 {
+		proto = protocolByPort(host)
+	}
 	return &DBStateRow{
 		Host:         host,
 		SNI:          sni,
+		Protocol:     proto,
 		Certificates: nil,
 		Valid:        UnknownState,
 	}
 }
 
+// parseDomain returns the host part of host:port, IPv6 literal is returned without brackets
 func parseDomain(host string) (domain string) {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return name
+	}
 	r, _ := regexp.Compile("[\\.\\-A-Za-z0-9]*")
 	domain = r.FindString(host)
 
 	return
 }
 
+// UpdateState checks the state, the change of a known state validity is damped
+// by `FailThreshold` consecutive checks
 func (mon Monitor) UpdateState(st *DBStateRow) {
-	certs := mon.GetCertificates(st.Host, st.SNI)
+	previous := *st
+	mon.checkState(st)
+	if dampState(st, &previous, mon.Cfg.FailThreshold) {
+		return
+	}
+	warning, critical := mon.Cfg.thresholds(st.Host, st.SNI)
+	st.Severity = stateSeverity(st, warning, critical, time.Now())
+}
+
+// dampState keeps the previous state until the new validity is confirmed threshold times in a row.
+// It reports whether the check is held, then the state is the previous one with the error and the failures
+// of the check, so the certificates and the severity are kept
+func dampState(st *DBStateRow, previous *DBStateRow, threshold int) bool {
+	if st.Valid == previous.Valid || previous.Valid == UnknownState {
+		st.Failures = 0
+		st.Pending = st.Valid
+		return false
+	}
+	failures := previous.Failures
+	if previous.Pending != st.Valid {
+		// the consecutive checks head to different states
+		failures = 0
+	}
+	failures++
+	if failures >= threshold {
+		st.Failures = 0
+		st.Pending = st.Valid
+		st.Flaps++
+		return false
+	}
+	checked := *st
+	*st = *previous
+	st.Error = checked.Error
+	st.ErrorCategory = checked.ErrorCategory
+	st.Alert = checked.Alert
+	st.Failures = failures
+	st.Pending = checked.Valid
+	st.held = true
+	return true
+}
+
+func (mon Monitor) checkState(st *DBStateRow) {
+	if len(st.Protocol) == 0 {
+		st.Protocol = protocolByPort(st.Host)
+	}
 	st.Certificates = make([]DBCertRow, 0, 1)
+	st.Findings = make([]DBStateFindingRow, 0)
 	st.Valid = ValidState
 	st.Description = ""
+	st.OCSP = ""
+	st.OCSPStapled = false
+	st.TLSVersion = ""
+	st.CipherSuite = ""
+	st.ALPN = ""
+	st.Latency = 0
+	st.WeakTLS = false
+	st.ErrorCategory = ""
+	st.Error = ""
+	st.Alert = 0
+	st.Distrusted = false
+	st.DANE = ""
+	st.HTTPStatus = 0
+	st.HTTPVersion = ""
+	st.HSTS = ""
+	st.HSTSMaxAge = -1
+	st.HSTSSubdomains = false
+	st.HSTSPreload = false
+	st.HTTPRedirect = ""
 
-	if certs == nil {
+	probe, err := mon.probeBackends(st)
+	if err != nil {
 		st.Valid = UnknownState
+		st.ErrorCategory = errorCategory(err)
+		st.Error = err.Error()
+		var probeErr *ProbeError
+		if errors.As(err, &probeErr) {
+			st.Alert = probeErr.Alert
+		}
 		return
 	}
+	certs := probe.PeerCertificates
+	st.TLSVersion = tls.VersionName(probe.Version)
+	st.CipherSuite = tls.CipherSuiteName(probe.CipherSuite)
+	st.ALPN = probe.NegotiatedProtocol
+	st.Latency = int(probe.Latency.Milliseconds())
+	if probe.HTTP != nil {
+		mon.checkHTTP(st, probe.HTTP)
+	}
+	if st.WeakTLS = isWeakTLS(probe.Version, probe.CipherSuite); st.WeakTLS {
+		st.Description = st.Description + fmt.Sprintf("\nWeak TLS parameters %s %s", st.TLSVersion, st.CipherSuite)
+	}
 	sni := st.SNI
 	for _, cert := range certs {
-		st.Certificates = append(st.Certificates, DBCertRow{
-			CommonName:  strings.ReplaceAll(cert.Subject.CommonName, "'", "''"),
-			NotAfter:    cert.NotAfter,
-			NotBefore:   cert.NotBefore,
-			Domains:     fmt.Sprintf("%s", cert.DNSNames),
-			Fingerprint: fingerprint(cert.Raw),
-			SubjectHash: fingerprint(cert.RawSubject),
-			IssuerHash:  fingerprint(cert.RawIssuer),
-			Expired:     int(cert.NotAfter.Sub(time.Now()).Seconds()),
-		})
+		row := newCertRow(cert)
+		row.Findings = mon.Cfg.LintCertificate(cert)
+		row.Class = classifyCert(cert, certs, time.Now())
+		st.Certificates = append(st.Certificates, row)
 		if err := CheckCertificate(cert, sni); err != nil {
 			st.Valid = InvalidState
 			st.Description = st.Description + "\n" + err.Error()
 		}
 		sni = ""
 	}
+
+	problems, err := CheckChain(certs, mon.Cfg.Roots(st.Host, st.SNI), time.Now())
+	st.Chain = strings.Join(problems, ",")
+	pkixValid := true
+	if err != nil {
+		for _, problem := range problems {
+			if problem != ChainWrongOrder && problem != ChainExtraCerts {
+				pkixValid = false
+			}
+		}
+		st.Description = st.Description + "\n" + err.Error()
+	}
+	mon.checkDistrust(st, certs, time.Now())
+	if mon.checkDANE(st, certs, pkixValid) && !pkixValid {
+		// DANE-TA and DANE-EE records replace the trusted root
+		st.Chain = daneChain(problems)
+	} else if !pkixValid {
+		st.Valid = InvalidState
+	}
+	mon.checkCAA(st, certs)
+	if pin := mon.statePin(st); pin != nil {
+		if err := checkPin(pin, certs); err != nil {
+			st.Valid = InvalidState
+			st.Description = st.Description + "\n" + err.Error()
+		}
+	}
+	mon.checkCRL(st, certs)
+	mon.checkOCSP(st, certs, probe.OCSPResponse)
 }
 
 func (mon Monitor) MaintainDB() {
@@ -155,4 +276,21 @@ func (mon Monitor) MaintainDB() {
 	mon.DB.DeleteStateBy(`
 		type=1 AND CAST(julianday('now') - julianday(last_discovery) AS integer) >= 1
 	`)
+	// Delete outdated OCSP responses
+	<-mon.DB.SingleWrite(`
+		DELETE FROM ocsp_responses WHERE julianday(next_update) < julianday('now');
+	`)
+	// Delete scans, backends, pins and findings of deleted states
+	<-mon.DB.SingleWrite(`
+		DELETE FROM scans WHERE NOT EXISTS (SELECT 1 FROM states s WHERE s.id = scans.state_id);
+		DELETE FROM state_backends WHERE NOT EXISTS (SELECT 1 FROM states s WHERE s.id = state_backends.state_id);
+		DELETE FROM state_pins WHERE NOT EXISTS (SELECT 1 FROM states s WHERE s.id = state_pins.state_id);
+		DELETE FROM state_findings WHERE NOT EXISTS (SELECT 1 FROM states s WHERE s.id = state_findings.state_id);
+	`)
+	// Delete lint findings, SANs and domains of deleted certificates
+	<-mon.DB.SingleWrite(`
+		DELETE FROM cert_findings WHERE NOT EXISTS (SELECT 1 FROM certs c WHERE c.fingerprint = cert_findings.fingerprint);
+		DELETE FROM cert_sans WHERE NOT EXISTS (SELECT 1 FROM certs c WHERE c.fingerprint = cert_sans.fingerprint);
+		DELETE FROM cert_domains WHERE NOT EXISTS (SELECT 1 FROM certs c WHERE c.fingerprint = cert_domains.fingerprint);
+	`)
 }
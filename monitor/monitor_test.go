@@ -0,0 +1,116 @@
+package monitor
+
+import (
+	"testing"
+)
+
+func TestDampState(t *testing.T) {
+	certs := []DBCertRow{{Fingerprint: "aa"}}
+	tests := []struct {
+		name         string
+		previous     DBStateRow
+		checked      DBStateRow
+		threshold    int
+		held         bool
+		wantValid    int
+		wantFailures int
+		wantFlaps    int
+	}{
+		{
+			name:      "same validity",
+			previous:  DBStateRow{Valid: ValidState, Failures: 2, Pending: InvalidState},
+			checked:   DBStateRow{Valid: ValidState},
+			threshold: 3,
+			wantValid: ValidState,
+		},
+		{
+			name:      "first check",
+			previous:  DBStateRow{Valid: UnknownState},
+			checked:   DBStateRow{Valid: InvalidState},
+			threshold: 3,
+			wantValid: InvalidState,
+		},
+		{
+			name:         "first failure is held",
+			previous:     DBStateRow{Valid: ValidState, Certificates: certs, Severity: SeverityOK},
+			checked:      DBStateRow{Valid: UnknownState, Error: "timeout", ErrorCategory: ErrorNetwork},
+			threshold:    3,
+			held:         true,
+			wantValid:    ValidState,
+			wantFailures: 1,
+		},
+		{
+			name:         "second failure is held",
+			previous:     DBStateRow{Valid: ValidState, Failures: 1, Pending: UnknownState, Certificates: certs},
+			checked:      DBStateRow{Valid: UnknownState},
+			threshold:    3,
+			held:         true,
+			wantValid:    ValidState,
+			wantFailures: 2,
+		},
+		{
+			name:      "confirmed change",
+			previous:  DBStateRow{Valid: ValidState, Failures: 2, Pending: UnknownState, Flaps: 1},
+			checked:   DBStateRow{Valid: UnknownState, Flaps: 1},
+			threshold: 3,
+			wantValid: UnknownState,
+			wantFlaps: 2,
+		},
+		{
+			name:         "pending target changes",
+			previous:     DBStateRow{Valid: ValidState, Failures: 2, Pending: UnknownState, Certificates: certs},
+			checked:      DBStateRow{Valid: InvalidState},
+			threshold:    3,
+			held:         true,
+			wantValid:    ValidState,
+			wantFailures: 1,
+		},
+		{
+			name:      "no damping",
+			previous:  DBStateRow{Valid: ValidState},
+			checked:   DBStateRow{Valid: InvalidState},
+			threshold: 0,
+			wantValid: InvalidState,
+			wantFlaps: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := tt.checked
+			if held := dampState(&st, &tt.previous, tt.threshold); held != tt.held {
+				t.Fatalf("dampState() = %v, want %v", held, tt.held)
+			}
+			if st.Valid != tt.wantValid || st.Failures != tt.wantFailures || st.Flaps != tt.wantFlaps {
+				t.Errorf("valid %d failures %d flaps %d, want %d %d %d",
+					st.Valid, st.Failures, st.Flaps, tt.wantValid, tt.wantFailures, tt.wantFlaps)
+			}
+			if !tt.held {
+				return
+			}
+			if len(st.Certificates) != len(tt.previous.Certificates) || st.Severity != tt.previous.Severity {
+				t.Error("previous certificates and severity are not kept")
+			}
+			if st.Error != tt.checked.Error || st.ErrorCategory != tt.checked.ErrorCategory {
+				t.Error("error of the check is not recorded")
+			}
+			if st.Pending != tt.checked.Valid {
+				t.Errorf("pending %d, want %d", st.Pending, tt.checked.Valid)
+			}
+		})
+	}
+}
+
+func TestDampStateAlternating(t *testing.T) {
+	st := DBStateRow{Valid: ValidState}
+	for i, valid := range []int{InvalidState, ValidState, InvalidState, ValidState, InvalidState} {
+		previous := st
+		st.Valid = valid
+		dampState(&st, &previous, 2)
+		if st.Valid != ValidState || st.Flaps != 0 {
+			t.Fatalf("check %d: valid %d flaps %d, want %d 0", i, st.Valid, st.Flaps, ValidState)
+		}
+		if st.Failures == 0 && st.Pending != st.Valid {
+			t.Errorf("check %d: pending %d is not reset", i, st.Pending)
+		}
+	}
+}
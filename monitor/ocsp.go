@@ -0,0 +1,185 @@
+package monitor
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// OCSPGood means that the certificate is not revoked
+	OCSPGood = "good"
+	// OCSPRevoked means that the certificate is revoked
+	OCSPRevoked = "revoked"
+	// OCSPUnknown means that the responder does not know the certificate
+	OCSPUnknown = "unknown"
+	// OCSPStaleStaple means that the stapled response is out of date
+	OCSPStaleStaple = "stale_staple"
+	// OCSPInvalid means that the response can not be parsed or verified
+	OCSPInvalid = "invalid"
+	// OCSPUnverified means that the stapled response is not trusted since the leaf issuer is not found
+	OCSPUnverified = "unverified"
+
+	maxOCSPResponseSize = 1024 * 1024
+)
+
+var ocspStatuses = map[int]string{
+	ocsp.Good:    OCSPGood,
+	ocsp.Revoked: OCSPRevoked,
+	ocsp.Unknown: OCSPUnknown,
+}
+
+// lookupIssuer looks for the certificate issuer in the served chain, the trust store and the stored certificates,
+// it is nil if the issuer is not found, then OCSP responses and CRLs can not be verified
+func (mon Monitor) lookupIssuer(st *DBStateRow, cert *x509.Certificate, certs []*x509.Certificate, now time.Time) *x509.Certificate {
+	candidates := trustPath(certs, mon.Cfg.Roots(st.Host, st.SNI), now)
+	for _, row := range mon.DB.GetCertificatesBy(fmt.Sprintf("WHERE subject_hash='%s'", fingerprint(cert.RawIssuer))) {
+		for _, der := range mon.DB.GetCertificateDERByID(row.ID) {
+			if c, err := x509.ParseCertificate(der); err == nil {
+				candidates = append(candidates, c)
+			}
+		}
+	}
+	for _, c := range candidates {
+		if !c.Equal(cert) && bytes.Equal(cert.RawIssuer, c.RawSubject) && cert.CheckSignatureFrom(c) == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+// markRevoked sets the revocation of the state leaf by OCSP status,
+// the revocation found by CRL is kept
+func markRevoked(st *DBStateRow, status string) {
+	if len(st.Certificates) == 0 {
+		return
+	}
+	switch status {
+	case OCSPRevoked:
+		st.Certificates[0].Revoked = CertRevoked
+	case OCSPGood:
+		if st.Certificates[0].Revoked == CertRevocationUnknown {
+			st.Certificates[0].Revoked = CertNotRevoked
+		}
+	}
+}
+
+// checkOCSPResponse parses the DER encoded response and returns the certificate status
+func checkOCSPResponse(der []byte, cert *x509.Certificate, issuer *x509.Certificate, now time.Time) (string, *ocsp.Response, error) {
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return OCSPInvalid, nil, err
+	}
+	status := ocspStatuses[resp.Status]
+	switch status {
+	case OCSPRevoked:
+		return status, resp, fmt.Errorf("Certificate %s is revoked at %s", certName(cert), resp.RevokedAt.Format(time.RFC3339))
+	case OCSPUnknown:
+		return status, resp, fmt.Errorf("Certificate %s is unknown for OCSP responder", certName(cert))
+	}
+	return status, resp, nil
+}
+
+// queryOCSP requests the certificate status from the responder listed in AIA
+func (mon Monitor) queryOCSP(cert *x509.Certificate, issuer *x509.Certificate) ([]byte, error) {
+	if len(cert.OCSPServer) == 0 {
+		return nil, errors.New("No OCSP responder")
+	}
+	request, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := http.Client{
+		Timeout: time.Duration(mon.Cfg.TLSTimeout) * time.Second,
+	}
+	resp, err := client.Post(cert.OCSPServer[0], "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s replied %s", cert.OCSPServer[0], resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize))
+}
+
+// fetchOCSP returns the response for the certificate from the cache or from the responder
+func (mon Monitor) fetchOCSP(cert *x509.Certificate, issuer *x509.Certificate, now time.Time) ([]byte, error) {
+	fp := fingerprint(cert.Raw)
+	if cached := mon.DB.GetOCSPResponse(fp); cached != nil && now.Before(cached.NextUpdate) {
+		return hex.DecodeString(cached.Response)
+	}
+	der, err := mon.queryOCSP(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.NextUpdate.IsZero() {
+		mon.DB.InsertOCSPResponse(DBOCSPRow{
+			Fingerprint: fp,
+			Response:    hex.EncodeToString(der),
+			ThisUpdate:  resp.ThisUpdate,
+			NextUpdate:  resp.NextUpdate,
+		})
+	}
+	return der, nil
+}
+
+// checkOCSP validates the stapled response and, if it is enabled, queries the responder
+func (mon Monitor) checkOCSP(st *DBStateRow, certs []*x509.Certificate, staple []byte) {
+	now := time.Now()
+	leaf := certs[0]
+	issuer := mon.lookupIssuer(st, leaf, certs, now)
+	st.OCSPStapled = len(staple) != 0
+
+	if st.OCSPStapled && issuer == nil {
+		// the response signature can not be checked, so its status is not trusted
+		st.OCSP = OCSPUnverified
+		st.Description = st.Description + fmt.Sprintf("\nStapled OCSP response is not verified, issuer %s is not found",
+			leaf.Issuer.CommonName)
+	} else if st.OCSPStapled {
+		status, resp, err := checkOCSPResponse(staple, leaf, issuer, now)
+		if err == nil && !resp.NextUpdate.IsZero() && now.After(resp.NextUpdate) {
+			status = OCSPStaleStaple
+			err = fmt.Errorf("Stapled OCSP response is out of date since %s", resp.NextUpdate.Format(time.RFC3339))
+		}
+		st.OCSP = status
+		markRevoked(st, status)
+		if err != nil {
+			if status == OCSPRevoked {
+				st.Valid = InvalidState
+			}
+			st.Description = st.Description + "\n" + err.Error()
+			return
+		}
+	}
+
+	if !mon.Cfg.OCSP || issuer == nil || len(leaf.OCSPServer) == 0 {
+		return
+	}
+	der, err := mon.fetchOCSP(leaf, issuer, now)
+	if err != nil {
+		st.Description = st.Description + "\nOCSP: " + err.Error()
+		return
+	}
+	status, _, err := checkOCSPResponse(der, leaf, issuer, now)
+	st.OCSP = status
+	markRevoked(st, status)
+	if err != nil {
+		if status == OCSPRevoked {
+			st.Valid = InvalidState
+		}
+		st.Description = st.Description + "\n" + err.Error()
+	}
+}
@@ -0,0 +1,129 @@
+package monitor
+
+import (
+	"context"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testMonitor returns the monitor with an empty database
+func testMonitor(t *testing.T) *Monitor {
+	dir := t.TempDir()
+	mon := NewMonitor()
+	mon.Cfg = &Config{TLSTimeout: 3, WorkDir: dir}
+	if err := mon.Cfg.loadTrustBundles(); err != nil {
+		t.Fatal(err)
+	}
+	mon.DB = OpenDB(filepath.Join(dir, "local.db"))
+	ctx, cancel := context.WithCancel(context.Background())
+	mon.DB.RunWriter(ctx)
+	t.Cleanup(cancel)
+	return mon
+}
+
+func TestCheckOCSP(t *testing.T) {
+	year := time.Now().AddDate(1, 0, 0)
+	root, rootKey := testCert(t, "OCSP Root", true, nil, nil, year)
+	forger, forgerKey := testCert(t, "OCSP Root", true, nil, nil, year)
+	revoked := map[string]bool{}
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		template := ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Hour),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+		if revoked[req.SerialNumber.String()] {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = time.Now().Add(-time.Minute)
+		}
+		der, _ := ocsp.CreateResponse(root, root, template, rootKey)
+		w.Write(der)
+	}))
+	defer responder.Close()
+
+	withOCSP := func(c *x509.Certificate) { c.OCSPServer = []string{responder.URL} }
+	good, _ := testCert(t, "good.example.com", false, root, rootKey, year, withOCSP)
+	bad, _ := testCert(t, "revoked.example.com", false, root, rootKey, year, withOCSP)
+	revoked[bad.SerialNumber.String()] = true
+
+	staple := func(signer *x509.Certificate, status int, serial *big.Int) []byte {
+		key := rootKey
+		if signer == forger {
+			key = forgerKey
+		}
+		der, err := ocsp.CreateResponse(signer, signer, ocsp.Response{
+			Status:       status,
+			SerialNumber: serial,
+			ThisUpdate:   time.Now().Add(-time.Hour),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	trusted := x509.NewCertPool()
+	trusted.AddCert(root)
+
+	tests := []struct {
+		name      string
+		certs     []*x509.Certificate
+		staple    []byte
+		query     bool
+		roots     *x509.CertPool
+		stored    bool
+		want      string
+		wantValid int
+	}{
+		{"good", []*x509.Certificate{good, root}, nil, true, nil, false, OCSPGood, ValidState},
+		{"revoked", []*x509.Certificate{bad, root}, nil, true, nil, false, OCSPRevoked, InvalidState},
+		{"good staple", []*x509.Certificate{good, root}, staple(root, ocsp.Good, good.SerialNumber), false,
+			nil, false, OCSPGood, ValidState},
+		{"revoked staple", []*x509.Certificate{bad, root}, staple(root, ocsp.Revoked, bad.SerialNumber), false,
+			nil, false, OCSPRevoked, InvalidState},
+		{"forged staple", []*x509.Certificate{bad, root}, staple(forger, ocsp.Good, bad.SerialNumber), false,
+			nil, false, OCSPInvalid, ValidState},
+		{"issuer is not found", []*x509.Certificate{bad}, staple(forger, ocsp.Good, bad.SerialNumber), true,
+			nil, false, OCSPUnverified, ValidState},
+		{"issuer is trusted", []*x509.Certificate{good}, staple(root, ocsp.Good, good.SerialNumber), false,
+			trusted, false, OCSPGood, ValidState},
+		{"issuer is stored", []*x509.Certificate{good}, staple(root, ocsp.Good, good.SerialNumber), false,
+			nil, true, OCSPGood, ValidState},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mon := testMonitor(t)
+			mon.Cfg.OCSP = tt.query
+			if tt.roots != nil {
+				mon.Cfg.TrustBundle = "test"
+				mon.Cfg.roots["test"] = tt.roots
+			}
+			if tt.stored {
+				if err := mon.DB.InsertCert(newCertRow(root)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			st := &DBStateRow{Host: "example.com:443", SNI: "example.com", Valid: ValidState}
+			st.Certificates = []DBCertRow{{Revoked: CertRevocationUnknown}}
+			mon.checkOCSP(st, tt.certs, tt.staple)
+			if st.OCSP != tt.want || st.Valid != tt.wantValid {
+				t.Errorf("ocsp %s valid %d, want %s %d: %s", st.OCSP, st.Valid, tt.want, tt.wantValid, st.Description)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package monitor
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Pin represents expected certificates of the host, empty lists are not checked
+//	SPKIHashes - allowed SHA-256 hashes of a public key in the served chain
+//	IssuerHashes - allowed subject hashes of the leaf certificate issuer
+//	Domains - names which the leaf certificate must cover
+type Pin struct {
+	SPKIHashes   []string `json:"spkiHashes,omitempty"`
+	IssuerHashes []string `json:"issuerHashes,omitempty"`
+	Domains      []string `json:"domains,omitempty"`
+}
+
+func spkiHash(cert *x509.Certificate) string {
+	return fmt.Sprintf("%x", sha256.Sum256(cert.RawSubjectPublicKeyInfo))
+}
+
+// Empty reports whether the pin has no restrictions
+func (pin *Pin) Empty() bool {
+	return len(pin.SPKIHashes) == 0 && len(pin.IssuerHashes) == 0 && len(pin.Domains) == 0
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// statePin returns the pin of the state, the pin set via API overrides the target and the zone ones
+func (mon Monitor) statePin(st *DBStateRow) *Pin {
+	if st.ID != 0 {
+		if row := mon.DB.GetPinByStateID(st.ID); row != nil && !row.Empty() {
+			return &row.Pin
+		}
+	}
+	if target := mon.Cfg.findTarget(st.Host, st.SNI); target != nil && target.Pin != nil {
+		return target.Pin
+	}
+	if zone := mon.Cfg.findZone(st.SNI); zone != nil && zone.Pin != nil {
+		return zone.Pin
+	}
+	return nil
+}
+
+// checkPin verifies the served chain against the pin
+func checkPin(pin *Pin, certs []*x509.Certificate) error {
+	if len(certs) == 0 {
+		return nil
+	}
+	leaf := certs[0]
+	problems := make([]string, 0)
+
+	if len(pin.SPKIHashes) != 0 {
+		pinned := false
+		for _, cert := range certs {
+			pinned = pinned || containsFold(pin.SPKIHashes, spkiHash(cert))
+		}
+		if !pinned {
+			problems = append(problems, fmt.Sprintf("no public key in the chain matches pinned SPKI hashes, leaf SPKI hash is %s",
+				spkiHash(leaf)))
+		}
+	}
+	if len(pin.IssuerHashes) != 0 {
+		if issuerHash := fingerprint(leaf.RawIssuer); !containsFold(pin.IssuerHashes, issuerHash) {
+			problems = append(problems, fmt.Sprintf("issuer %s (%s) is not pinned", leaf.Issuer.String(), issuerHash))
+		}
+	}
+	for _, domain := range pin.Domains {
+		if err := leaf.VerifyHostname(domain); err != nil {
+			problems = append(problems, fmt.Sprintf("%s is not covered", domain))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("Pin is broken: " + strings.Join(problems, "; "))
+}
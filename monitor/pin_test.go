@@ -0,0 +1,80 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+func TestCheckPin(t *testing.T) {
+	root, inter, leaf := testChain(t)
+	other, _ := testCert(t, "Other", true, nil, nil, leaf.NotAfter)
+	certs := []*x509.Certificate{leaf, inter}
+
+	tests := []struct {
+		name string
+		pin  Pin
+		want bool
+	}{
+		{"empty", Pin{}, true},
+		{"leaf SPKI", Pin{SPKIHashes: []string{spkiHash(leaf)}}, true},
+		{"intermediate SPKI upper case", Pin{SPKIHashes: []string{strings.ToUpper(spkiHash(inter))}}, true},
+		{"root SPKI is not served", Pin{SPKIHashes: []string{spkiHash(root)}}, false},
+		{"other SPKI", Pin{SPKIHashes: []string{spkiHash(other), spkiHash(leaf)}}, true},
+		{"issuer", Pin{IssuerHashes: []string{fingerprint(inter.RawSubject)}}, true},
+		{"root issuer", Pin{IssuerHashes: []string{fingerprint(root.RawSubject)}}, false},
+		{"domain", Pin{Domains: []string{"leaf.example.com"}}, true},
+		{"uncovered domain", Pin{Domains: []string{"leaf.example.com", "www.example.com"}}, false},
+		{"all match", Pin{
+			SPKIHashes:   []string{spkiHash(leaf)},
+			IssuerHashes: []string{fingerprint(inter.RawSubject)},
+			Domains:      []string{"leaf.example.com"},
+		}, true},
+		{"SPKI matches but issuer not", Pin{
+			SPKIHashes:   []string{spkiHash(leaf)},
+			IssuerHashes: []string{fingerprint(other.RawSubject)},
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkPin(&tt.pin, certs); (err == nil) != tt.want {
+				t.Errorf("checkPin() error = %v, want pinned %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatePin(t *testing.T) {
+	target := &Pin{Domains: []string{"target"}}
+	zone := &Pin{Domains: []string{"zone"}}
+	mon := testMonitor(t)
+	mon.Cfg.Targets = []TargetConfig{{Host: "target.example.com:443", Pin: target}}
+	mon.Cfg.Zones = []ZoneConfig{{Name: "example.com.", Pin: zone}}
+	mon.DB.InsertState(DBStateRow{Host: "api.example.com:443", SNI: "api.example.com", Protocol: ProtoTLS})
+	api := mon.DB.GetStatesBy("WHERE host='api.example.com:443'")[0]
+	if err := mon.DB.InsertPin(DBPinRow{Pin: Pin{Domains: []string{"api"}}, StateID: api.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		st   DBStateRow
+		want string
+	}{
+		{"API", api, "api"},
+		{"target", DBStateRow{Host: "target.example.com:443", SNI: "target.example.com"}, "target"},
+		{"zone", DBStateRow{Host: "www.example.com:443", SNI: "www.example.com"}, "zone"},
+		{"none", DBStateRow{Host: "www.example.org:443", SNI: "www.example.org"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if pin := mon.statePin(&tt.st); pin != nil {
+				got = pin.Domains[0]
+			}
+			if got != tt.want {
+				t.Errorf("statePin() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,439 @@
+package monitor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// VersionSSL30 is SSLv3 protocol version, crypto/tls does not support it
+	VersionSSL30 = 0x0300
+	versionSSL30 = "SSLv3"
+
+	recordAlert       = 0x15
+	recordHandshake   = 0x16
+	handshakeHello    = 0x01
+	handshakeServer   = 0x02
+	maxHandshakeTries = 64
+	maxRecordSize     = 16384 + 2048
+
+	extensionSupportedVersions = 0x002b
+)
+
+// SSLv3 cipher suites offered by the raw ClientHello
+var ssl3CipherSuites = []uint16{
+	0x0004, 0x0005, 0x000a, 0x002f, 0x0035, 0x0009, 0x0013, 0x0016, 0x0033, 0x0039,
+}
+
+// rawCipherSuites are offered by raw ClientHellos, crypto/tls does not implement DHE, Camellia
+// and some legacy suites, so the servers accepting only them are also enumerated
+var rawCipherSuites = map[uint16]string{
+	0x0004: "TLS_RSA_WITH_RC4_128_MD5",
+	0x0005: "TLS_RSA_WITH_RC4_128_SHA",
+	0x0009: "TLS_RSA_WITH_DES_CBC_SHA",
+	0x000a: "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x0013: "TLS_DHE_DSS_WITH_3DES_EDE_CBC_SHA",
+	0x0016: "TLS_DHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x002f: "TLS_RSA_WITH_AES_128_CBC_SHA",
+	0x0032: "TLS_DHE_DSS_WITH_AES_128_CBC_SHA",
+	0x0033: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA",
+	0x0035: "TLS_RSA_WITH_AES_256_CBC_SHA",
+	0x0038: "TLS_DHE_DSS_WITH_AES_256_CBC_SHA",
+	0x0039: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA",
+	0x003c: "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	0x003d: "TLS_RSA_WITH_AES_256_CBC_SHA256",
+	0x0041: "TLS_RSA_WITH_CAMELLIA_128_CBC_SHA",
+	0x0045: "TLS_DHE_RSA_WITH_CAMELLIA_128_CBC_SHA",
+	0x0067: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA256",
+	0x006b: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA256",
+	0x0084: "TLS_RSA_WITH_CAMELLIA_256_CBC_SHA",
+	0x0088: "TLS_DHE_RSA_WITH_CAMELLIA_256_CBC_SHA",
+	0x009c: "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	0x009d: "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	0x009e: "TLS_DHE_RSA_WITH_AES_128_GCM_SHA256",
+	0x009f: "TLS_DHE_RSA_WITH_AES_256_GCM_SHA384",
+	0xc007: "TLS_ECDHE_ECDSA_WITH_RC4_128_SHA",
+	0xc008: "TLS_ECDHE_ECDSA_WITH_3DES_EDE_CBC_SHA",
+	0xc009: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	0xc00a: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	0xc011: "TLS_ECDHE_RSA_WITH_RC4_128_SHA",
+	0xc012: "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0xc013: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	0xc014: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	0xc023: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	0xc024: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384",
+	0xc027: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	0xc028: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384",
+	0xc02b: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	0xc02c: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	0xc02f: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	0xc030: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	0xcca8: "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xcca9: "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xccaa: "TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+// tls13CipherSuites are offered by raw TLS 1.3 ClientHellos
+var tls13CipherSuites = map[uint16]string{
+	0x1301: "TLS_AES_128_GCM_SHA256",
+	0x1302: "TLS_AES_256_GCM_SHA384",
+	0x1303: "TLS_CHACHA20_POLY1305_SHA256",
+	0x1304: "TLS_AES_128_CCM_SHA256",
+	0x1305: "TLS_AES_128_CCM_8_SHA256",
+}
+
+// scanVersions are the protocol versions which are enumerated with cipher suites
+var scanVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// suiteName returns the name of the raw cipher suite
+func suiteName(version uint16, suite uint16) string {
+	if version >= tls.VersionTLS13 {
+		return tls13CipherSuites[suite]
+	}
+	return rawCipherSuites[suite]
+}
+
+// offeredSuites returns the raw cipher suites applicable to the protocol version,
+// SHA-256/384 based suites are defined since TLS 1.2 and TLS 1.3 has its own suites
+func offeredSuites(version uint16) []uint16 {
+	known := rawCipherSuites
+	if version >= tls.VersionTLS13 {
+		known = tls13CipherSuites
+	}
+	suites := make([]uint16, 0, len(known))
+	for id, name := range known {
+		if version < tls.VersionTLS12 && (strings.HasSuffix(name, "_SHA256") || strings.HasSuffix(name, "_SHA384")) {
+			continue
+		}
+		suites = append(suites, id)
+	}
+	sort.Slice(suites, func(i, j int) bool { return suites[i] < suites[j] })
+	return suites
+}
+
+// uint16Bytes prefixes the data with its length
+func uint16Bytes(data []byte) []byte {
+	return append([]byte{byte(len(data) >> 8), byte(len(data))}, data...)
+}
+
+// clientHello builds the ClientHello record of the protocol version offering the cipher suites,
+// SSLv3 ClientHello has no extensions, TLS 1.3 is offered by supported_versions with x25519 key share
+func clientHello(version uint16, suites []uint16, sni string) []byte {
+	// client_version, random, session_id, cipher_suites, compression_methods
+	legacyVersion := version
+	if version >= tls.VersionTLS13 {
+		legacyVersion = tls.VersionTLS12
+	}
+	body := []byte{byte(legacyVersion >> 8), byte(legacyVersion)}
+	random := make([]byte, 32)
+	rand.Read(random)
+	body = append(body, random...)
+	body = append(body, 0x00)
+	ids := make([]byte, 0, len(suites)*2)
+	for _, suite := range suites {
+		ids = append(ids, byte(suite>>8), byte(suite))
+	}
+	body = append(body, uint16Bytes(ids)...)
+	body = append(body, 0x01, 0x00)
+
+	if version > VersionSSL30 {
+		var extensions []byte
+		if len(sni) != 0 && net.ParseIP(sni) == nil {
+			name := append([]byte{0x00}, uint16Bytes([]byte(sni))...)
+			extensions = append(extensions, 0x00, 0x00)
+			extensions = append(extensions, uint16Bytes(uint16Bytes(name))...)
+		}
+		// supported_groups: x25519, secp256r1, secp384r1, secp521r1
+		extensions = append(extensions, 0x00, 0x0a)
+		extensions = append(extensions, uint16Bytes(uint16Bytes([]byte{0x00, 0x1d, 0x00, 0x17, 0x00, 0x18, 0x00, 0x19}))...)
+		// ec_point_formats: uncompressed
+		extensions = append(extensions, 0x00, 0x0b, 0x00, 0x02, 0x01, 0x00)
+		if version >= tls.VersionTLS12 {
+			// signature_algorithms: RSA PKCS#1, ECDSA and RSA-PSS with SHA-256/384/512, then SHA-1
+			algorithms := []byte{0x04, 0x01, 0x05, 0x01, 0x06, 0x01, 0x04, 0x03, 0x05, 0x03, 0x06, 0x03,
+				0x08, 0x04, 0x08, 0x05, 0x08, 0x06, 0x02, 0x01, 0x02, 0x03}
+			extensions = append(extensions, 0x00, 0x0d)
+			extensions = append(extensions, uint16Bytes(uint16Bytes(algorithms))...)
+		}
+		if version >= tls.VersionTLS13 {
+			// supported_versions and key_share of x25519, the server does not need the valid key
+			// to choose the cipher suite in ServerHello
+			extensions = append(extensions, 0x00, extensionSupportedVersions, 0x00, 0x03, 0x02,
+				byte(version>>8), byte(version))
+			share := make([]byte, 32)
+			rand.Read(share)
+			share = append([]byte{0x00, 0x1d}, uint16Bytes(share)...)
+			extensions = append(extensions, 0x00, 0x33)
+			extensions = append(extensions, uint16Bytes(uint16Bytes(share))...)
+		}
+		// renegotiation_info
+		extensions = append(extensions, 0xff, 0x01, 0x00, 0x01, 0x00)
+		body = append(body, uint16Bytes(extensions)...)
+	}
+
+	handshake := append([]byte{handshakeHello, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+	recordVersion := uint16(tls.VersionTLS10)
+	if version < recordVersion {
+		recordVersion = version
+	}
+	return append([]byte{recordHandshake, byte(recordVersion >> 8), byte(recordVersion)}, uint16Bytes(handshake)...)
+}
+
+// readHandshake reads handshake records and returns the first handshake message,
+// the message may be fragmented over several records
+func readHandshake(r io.Reader) ([]byte, error) {
+	var message []byte
+
+	header := make([]byte, 5)
+	for {
+		if len(message) >= 4 {
+			size := 4 + (int(message[1])<<16 | int(message[2])<<8 | int(message[3]))
+			if size > maxPreambleSize {
+				return nil, errors.New("Too long handshake message")
+			}
+			if len(message) >= size {
+				return message[:size], nil
+			}
+		}
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(header[3:]))
+		if length > maxRecordSize {
+			return nil, errors.New("Too long TLS record")
+		}
+		record := make([]byte, length)
+		if _, err := io.ReadFull(r, record); err != nil {
+			return nil, err
+		}
+		switch header[0] {
+		case recordHandshake:
+			message = append(message, record...)
+		case recordAlert:
+			if len(record) == 2 {
+				return nil, tls.AlertError(record[1])
+			}
+			return nil, errors.New("Malformed TLS alert")
+		default:
+			return nil, fmt.Errorf("Unexpected TLS record type %d", header[0])
+		}
+	}
+}
+
+// parseServerHello returns the protocol version and the cipher suite chosen by ServerHello,
+// the version of TLS 1.3 is taken from supported_versions extension
+func parseServerHello(message []byte) (uint16, uint16, bool) {
+	// handshake type and length, server_version, random, session_id
+	if len(message) < 4+2+32+1 || message[0] != handshakeServer {
+		return 0, 0, false
+	}
+	version := binary.BigEndian.Uint16(message[4:])
+	offset := 4 + 2 + 32
+	offset += 1 + int(message[offset])
+	// cipher_suite, compression_method
+	if len(message) < offset+3 {
+		return 0, 0, false
+	}
+	suite := binary.BigEndian.Uint16(message[offset:])
+	offset += 3
+	if len(message) < offset+2 {
+		return version, suite, true
+	}
+	end := offset + 2 + int(binary.BigEndian.Uint16(message[offset:]))
+	if end > len(message) {
+		return 0, 0, false
+	}
+	for offset += 2; offset+4 <= end; {
+		extension := binary.BigEndian.Uint16(message[offset:])
+		length := int(binary.BigEndian.Uint16(message[offset+2:]))
+		offset += 4
+		if offset+length > end {
+			return 0, 0, false
+		}
+		if extension == extensionSupportedVersions && length == 2 {
+			version = binary.BigEndian.Uint16(message[offset:])
+		}
+		offset += length
+	}
+	return version, suite, true
+}
+
+// serverHello sends the raw ClientHello and returns the cipher suite chosen by the server,
+// it fails if the server rejects the offer or negotiates another protocol version
+func (mon Monitor) serverHello(host string, sni string, proto string, version uint16, suites []uint16) (uint16, bool) {
+	conn, err := mon.dialPlain(host, proto, sni)
+	if err != nil {
+		return 0, false
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(clientHello(version, suites, sni)); err != nil {
+		return 0, false
+	}
+	message, err := readHandshake(conn)
+	if err != nil {
+		return 0, false
+	}
+	negotiated, suite, ok := parseServerHello(message)
+	if !ok || negotiated != version {
+		return 0, false
+	}
+	return suite, true
+}
+
+// acceptsSSL3 sends SSLv3 ClientHello and reports whether the server answers with SSLv3 ServerHello
+func (mon Monitor) acceptsSSL3(host string, sni string, proto string) bool {
+	_, ok := mon.serverHello(host, sni, proto, VersionSSL30, ssl3CipherSuites)
+	return ok
+}
+
+// acceptedSuites enumerates cipher suites of the protocol version, the offer is repeated
+// without the chosen suite until the server rejects it
+func (mon Monitor) acceptedSuites(host string, sni string, proto string, version uint16) []uint16 {
+	offered := offeredSuites(version)
+	accepted := make([]uint16, 0, 1)
+	for i := 0; i < maxHandshakeTries && len(offered) != 0; i++ {
+		suite, ok := mon.serverHello(host, sni, proto, version, offered)
+		if !ok {
+			break
+		}
+		rest := make([]uint16, 0, len(offered))
+		for _, id := range offered {
+			if id != suite {
+				rest = append(rest, id)
+			}
+		}
+		if len(rest) == len(offered) {
+			// the server chose the suite which is not offered
+			break
+		}
+		accepted = append(accepted, suite)
+		offered = rest
+	}
+	return accepted
+}
+
+// ScanState enumerates protocol versions and cipher suites accepted by the host with raw ClientHellos
+func (mon Monitor) ScanState(st DBStateRow) DBScanRow {
+	scan := DBScanRow{
+		StateID:      st.ID,
+		Versions:     make([]string, 0, 1),
+		CipherSuites: make([]string, 0, 1),
+		Findings:     make([]string, 0, 1),
+	}
+	proto := st.Protocol
+	if len(proto) == 0 {
+		proto = protocolByPort(st.Host)
+	}
+
+	if mon.acceptsSSL3(st.Host, st.SNI, proto) {
+		scan.Versions = append(scan.Versions, versionSSL30)
+		scan.Findings = append(scan.Findings, "SSLv3 is accepted")
+	}
+
+	var accepted []string
+	tls13 := false
+	for _, version := range scanVersions {
+		suites := mon.acceptedSuites(st.Host, st.SNI, proto, version)
+		if len(suites) == 0 {
+			continue
+		}
+		name := tls.VersionName(version)
+		scan.Versions = append(scan.Versions, name)
+		if version < tls.VersionTLS12 {
+			scan.Findings = append(scan.Findings, name+" is accepted")
+		}
+		for _, suite := range suites {
+			scan.CipherSuites = append(scan.CipherSuites, name+" "+suiteName(version, suite))
+		}
+		if version >= tls.VersionTLS13 {
+			tls13 = true
+			continue
+		}
+		for _, suite := range suites {
+			accepted = append(accepted, suiteName(version, suite))
+		}
+	}
+
+	scan.Findings = append(scan.Findings, cipherFindings(accepted, tls13)...)
+	scan.TS = time.Now()
+	return scan
+}
+
+// cipherFindings flags weak cipher suites among the accepted ones by their names
+func cipherFindings(accepted []string, tls13 bool) []string {
+	var rc4, des, aead, fs bool
+
+	findings := make([]string, 0, 1)
+	for _, name := range accepted {
+		rc4 = rc4 || strings.Contains(name, "_RC4_")
+		des = des || strings.Contains(name, "_3DES_") || strings.Contains(name, "_DES_")
+		aead = aead || strings.Contains(name, "_GCM_") || strings.Contains(name, "_CHACHA20_POLY1305")
+		fs = fs || strings.HasPrefix(name, "TLS_ECDHE_") || strings.HasPrefix(name, "TLS_DHE_")
+	}
+	if rc4 {
+		findings = append(findings, "RC4 cipher suites are accepted")
+	}
+	if des {
+		findings = append(findings, "DES or 3DES cipher suites are accepted")
+	}
+	if len(accepted) != 0 && !aead && !tls13 {
+		findings = append(findings, "Only CBC cipher suites are accepted")
+	}
+	if len(accepted) != 0 && !fs && !tls13 {
+		findings = append(findings, "Forward secrecy is not supported")
+	}
+	return findings
+}
+
+// scanEnabled reports whether the deep scan is enabled for the state
+func (mon Monitor) scanEnabled(st DBStateRow) bool {
+	if st.Scan {
+		return true
+	}
+	target := mon.Cfg.findTarget(st.Host, st.SNI)
+	return target != nil && target.Scan
+}
+
+// RunScanner periodically scans the states with enabled deep scan
+func (mon *Monitor) RunScanner(ctx context.Context) {
+	if mon.Cfg.ScanDelay <= 0 {
+		return
+	}
+	delay := time.Second * time.Duration(mon.Cfg.ScanDelay)
+	ticker := time.NewTicker(delay)
+	go func() {
+		time.Sleep(time.Second * time.Duration(warmUpDealy))
+		for {
+			for _, state := range mon.DB.GetStatesBy("") {
+				if !mon.scanEnabled(state) {
+					continue
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				scan := mon.ScanState(state)
+				if err := mon.DB.InsertScan(scan); err != nil {
+					log.Println(err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,154 @@
+package monitor
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testServerHello returns ServerHello message with the legacy version, the suite and the extensions
+func testServerHello(version uint16, suite uint16, extensions []byte) []byte {
+	body := []byte{byte(version >> 8), byte(version)}
+	body = append(body, make([]byte, 32)...)
+	body = append(body, 0x20)
+	body = append(body, make([]byte, 32)...)
+	body = append(body, byte(suite>>8), byte(suite), 0x00)
+	if extensions != nil {
+		body = append(body, uint16Bytes(extensions)...)
+	}
+	return append([]byte{handshakeServer, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+// testRecord returns TLS record of the content type
+func testRecord(content byte, data []byte) []byte {
+	return append([]byte{content, 0x03, 0x03}, uint16Bytes(data)...)
+}
+
+func TestParseServerHello(t *testing.T) {
+	supportedTLS13 := []byte{0x00, 0x2b, 0x00, 0x02, 0x03, 0x04}
+	renegotiation := []byte{0xff, 0x01, 0x00, 0x01, 0x00}
+	tests := []struct {
+		name    string
+		message []byte
+		version uint16
+		suite   uint16
+		ok      bool
+	}{
+		{"SSLv3 without extensions", testServerHello(VersionSSL30, 0x000a, nil), VersionSSL30, 0x000a, true},
+		{"TLS 1.2", testServerHello(tls.VersionTLS12, 0xc02f, renegotiation), tls.VersionTLS12, 0xc02f, true},
+		{"TLS 1.3", testServerHello(tls.VersionTLS12, 0x1301, append(renegotiation, supportedTLS13...)),
+			tls.VersionTLS13, 0x1301, true},
+		{"truncated", testServerHello(tls.VersionTLS12, 0xc02f, nil)[:40], 0, 0, false},
+		{"extensions overflow", testServerHello(tls.VersionTLS12, 0x1301, supportedTLS13)[:80], 0, 0, false},
+		{"not ServerHello", append([]byte{0x0b}, testServerHello(tls.VersionTLS12, 0xc02f, nil)[1:]...), 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, suite, ok := parseServerHello(tt.message)
+			if version != tt.version || suite != tt.suite || ok != tt.ok {
+				t.Errorf("parseServerHello() = %#04x %#04x %v, want %#04x %#04x %v",
+					version, suite, ok, tt.version, tt.suite, tt.ok)
+			}
+		})
+	}
+}
+
+func TestReadHandshake(t *testing.T) {
+	hello := testServerHello(tls.VersionTLS12, 0xc02f, nil)
+	tests := []struct {
+		name    string
+		stream  []byte
+		wantErr bool
+	}{
+		{"single record", testRecord(recordHandshake, hello), false},
+		{"fragmented", append(testRecord(recordHandshake, hello[:3]), testRecord(recordHandshake, hello[3:])...), false},
+		{"with the next message", testRecord(recordHandshake, append(hello, 0x0b, 0x00, 0x00, 0x00)), false},
+		{"alert", testRecord(recordAlert, []byte{0x02, 0x28}), true},
+		{"truncated record", testRecord(recordHandshake, hello)[:30], true},
+		{"truncated message", testRecord(recordHandshake, hello[:30]), true},
+		{"unexpected record", testRecord(0x17, hello), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := readHandshake(bytes.NewReader(tt.stream))
+			if tt.wantErr {
+				if err == nil {
+					t.Error("readHandshake() succeeds")
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(message, hello) {
+				t.Errorf("readHandshake() = %x, %v", message, err)
+			}
+		})
+	}
+}
+
+func TestCipherFindings(t *testing.T) {
+	tests := []struct {
+		name     string
+		accepted []string
+		tls13    bool
+		want     []string
+	}{
+		{"modern", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, true, []string{}},
+		{"TLS 1.3 only", nil, true, []string{}},
+		{"RC4 and 3DES", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA",
+			"TLS_RSA_WITH_3DES_EDE_CBC_SHA"}, false,
+			[]string{"RC4 cipher suites are accepted", "DES or 3DES cipher suites are accepted"}},
+		{"CBC with DHE", []string{"TLS_DHE_RSA_WITH_AES_128_CBC_SHA"}, false,
+			[]string{"Only CBC cipher suites are accepted"}},
+		{"static RSA", []string{"TLS_RSA_WITH_AES_128_GCM_SHA256"}, false,
+			[]string{"Forward secrecy is not supported"}},
+		{"DHE AEAD", []string{"TLS_DHE_RSA_WITH_AES_128_GCM_SHA256"}, false, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cipherFindings(tt.accepted, tt.tls13); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cipherFindings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcceptedSuites(t *testing.T) {
+	mon := testMonitor(t)
+	tests := []struct {
+		name    string
+		config  *tls.Config
+		version uint16
+		want    []uint16
+	}{
+		{"TLS 1.2", &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA}}, tls.VersionTLS12,
+			[]uint16{0x000a, 0xc014, 0xc02f}},
+		{"TLS 1.0", &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS10, CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA}}, tls.VersionTLS10, []uint16{0xc013}},
+		{"TLS 1.2 is rejected", &tls.Config{MinVersion: tls.VersionTLS13}, tls.VersionTLS12, []uint16{}},
+		{"TLS 1.3", &tls.Config{MinVersion: tls.VersionTLS13}, tls.VersionTLS13, []uint16{0x1301, 0x1302, 0x1303}},
+		{"TLS 1.3 is not supported", &tls.Config{MaxVersion: tls.VersionTLS12}, tls.VersionTLS13, []uint16{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+			srv.TLS = tt.config
+			srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+			srv.StartTLS()
+			defer srv.Close()
+
+			got := mon.acceptedSuites(strings.TrimPrefix(srv.URL, "https://"), "example.com", ProtoTLS, tt.version)
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("acceptedSuites() = %#04x, want %#04x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package monitor
+
+import (
+	"strings"
+)
+
+const (
+	// MatchExact means that the certificate lists the searched name
+	MatchExact = "exact"
+	// MatchWildcard means that the certificate wildcard covers the searched name
+	MatchWildcard = "wildcard"
+	// MatchCovered means that the searched wildcard covers the certificate name
+	MatchCovered = "covered"
+)
+
+// normalizeDomain lowercases the name and strips the root dot
+func normalizeDomain(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// parentDomain strips the first label, e.g. *.pay.example.com -> pay.example.com
+func parentDomain(name string) string {
+	if i := strings.Index(name, "."); i >= 0 {
+		return name[i+1:]
+	}
+	return ""
+}
+
+// domainRows lists DNS names of the certificate for table `cert_domains`
+func domainRows(sans []DBSANRow) []DBDomainRow {
+	domains := make([]DBDomainRow, 0, len(sans))
+	for _, san := range sans {
+		if san.Type != SANDNS {
+			continue
+		}
+		name := normalizeDomain(san.Value)
+		domains = append(domains, DBDomainRow{
+			Fingerprint: san.Fingerprint,
+			Name:        name,
+			Parent:      parentDomain(name),
+			Wildcard:    strings.HasPrefix(name, "*."),
+		})
+	}
+	return domains
+}
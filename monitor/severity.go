@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"time"
+)
+
+const (
+	// SeverityOK means that the state is valid and far from expiration
+	SeverityOK = "ok"
+	// SeverityWarning means that a certificate expires within the warning threshold
+	SeverityWarning = "warning"
+	// SeverityCritical means that a certificate expires within the critical threshold
+	//	or the state is invalid
+	SeverityCritical = "critical"
+	// SeverityExpired means that a certificate is expired
+	SeverityExpired = "expired"
+	// SeverityUnknown means that the state is not checked
+	SeverityUnknown = "unknown"
+
+	defaultWarningDays  = 30
+	defaultCriticalDays = 7
+)
+
+// Severities lists all state severities
+var Severities = []string{SeverityOK, SeverityWarning, SeverityCritical, SeverityExpired, SeverityUnknown}
+
+// ValidSeverity reports whether the severity is known
+func ValidSeverity(severity string) bool {
+	for _, s := range Severities {
+		if s == severity {
+			return true
+		}
+	}
+	return false
+}
+
+// thresholds returns warning and critical days for the host
+func (cfg *Config) thresholds(host string, sni string) (warning int, critical int) {
+	warning, critical = cfg.WarningDays, cfg.CriticalDays
+	if zone := cfg.findZone(sni); zone != nil {
+		if zone.WarningDays != 0 {
+			warning = zone.WarningDays
+		}
+		if zone.CriticalDays != 0 {
+			critical = zone.CriticalDays
+		}
+	}
+	if target := cfg.findTarget(host, sni); target != nil {
+		if target.WarningDays != 0 {
+			warning = target.WarningDays
+		}
+		if target.CriticalDays != 0 {
+			critical = target.CriticalDays
+		}
+	}
+	return
+}
+
+// expirySeverity returns severity of the certificate expiring at notAfter
+func expirySeverity(notAfter time.Time, warning int, critical int, now time.Time) string {
+	left := notAfter.Sub(now)
+	switch {
+	case left <= 0:
+		return SeverityExpired
+	case left <= time.Duration(critical)*24*time.Hour:
+		return SeverityCritical
+	case left <= time.Duration(warning)*24*time.Hour:
+		return SeverityWarning
+	}
+	return SeverityOK
+}
+
+// stateSeverity returns severity of the state by its validity and the nearest expiration in the chain
+func stateSeverity(st *DBStateRow, warning int, critical int, now time.Time) string {
+	if st.Valid == UnknownState || len(st.Certificates) == 0 {
+		return SeverityUnknown
+	}
+	notAfter := st.Certificates[0].NotAfter
+	for _, cert := range st.Certificates {
+		if cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	severity := expirySeverity(notAfter, warning, critical, now)
+	if st.Valid == InvalidState && severity != SeverityExpired {
+		return SeverityCritical
+	}
+	return severity
+}
+
+// CertSeverity sets severity of the certificates by the thresholds of the states serving them,
+// the largest thresholds are applied to the certificate served by several states and
+// the global thresholds to the certificate served by none
+func (mon Monitor) CertSeverity(certs []DBCertRow) {
+	fingerprints := make([]string, 0, len(certs))
+	for _, cert := range certs {
+		fingerprints = append(fingerprints, cert.Fingerprint)
+	}
+	states := mon.DB.GetStateNamesByCertificate(fingerprints)
+
+	now := time.Now()
+	for i := range certs {
+		warning, critical := mon.certThresholds(states[certs[i].Fingerprint])
+		certs[i].Severity = expirySeverity(certs[i].NotAfter, warning, critical, now)
+	}
+}
+
+// certThresholds returns the largest warning and critical days among the states
+func (mon Monitor) certThresholds(states []DBStateRow) (warning int, critical int) {
+	if len(states) == 0 {
+		return mon.Cfg.WarningDays, mon.Cfg.CriticalDays
+	}
+	for _, st := range states {
+		w, c := mon.Cfg.thresholds(st.Host, st.SNI)
+		if w > warning {
+			warning = w
+		}
+		if c > critical {
+			critical = c
+		}
+	}
+	return
+}
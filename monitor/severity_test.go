@@ -0,0 +1,50 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCertSeverity(t *testing.T) {
+	mon := testMonitor(t)
+	mon.Cfg.WarningDays, mon.Cfg.CriticalDays = 10, 5
+	mon.Cfg.Zones = []ZoneConfig{{Name: "example.com.", WarningDays: 30}}
+	mon.Cfg.Targets = []TargetConfig{{Host: "strict.example.org:443", CriticalDays: 25}}
+
+	cert, _ := testCert(t, "leaf.example.com", false, nil, nil, time.Now().AddDate(0, 0, 20))
+	row := newCertRow(cert)
+	if err := mon.DB.InsertCert(row); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		host string
+		sni  string
+		want string
+	}{
+		{"not served", "", "", SeverityOK},
+		{"zone thresholds", "leaf.example.com:443", "leaf.example.com", SeverityWarning},
+		{"target thresholds", "strict.example.org:443", "strict.example.org", SeverityCritical},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.host) != 0 {
+				st := NewState(tt.host, tt.sni, "")
+				if err := mon.DB.InsertState(*st); err != nil {
+					t.Fatal(err)
+				}
+				st.Valid = ValidState
+				st.Certificates = []DBCertRow{row}
+				if err := mon.DB.UpdateState(st); err != nil {
+					t.Fatal(err)
+				}
+			}
+			certs := []DBCertRow{row}
+			mon.CertSeverity(certs)
+			if certs[0].Severity != tt.want {
+				t.Errorf("CertSeverity() = %s, want %s", certs[0].Severity, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+)
+
+// SimulatedCause represents the certificate which breaks the chain on the simulated date,
+// the fingerprint is empty if the culprit is not found
+type SimulatedCause struct {
+	CommonName  string    `json:"commonName"`
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"notAfter"`
+	NotBefore   time.Time `json:"notBefore"`
+	Reason      string    `json:"reason"`
+	Served      bool      `json:"served"`
+}
+
+// SimulatedState represents the state which fails validation on the simulated date
+type SimulatedState struct {
+	DBStateRow
+	Causes []SimulatedCause `json:"causes"`
+}
+
+func simulatedCause(cert *x509.Certificate, served bool, reason string) SimulatedCause {
+	return SimulatedCause{
+		CommonName:  certName(cert),
+		Fingerprint: fingerprint(cert.Raw),
+		NotAfter:    cert.NotAfter,
+		NotBefore:   cert.NotBefore,
+		Reason:      reason,
+		Served:      served,
+	}
+}
+
+// outOfValidity reports whether the certificate is expired or inactive yet at the moment
+func outOfValidity(cert *x509.Certificate, at time.Time) bool {
+	return at.After(cert.NotAfter) || at.Before(cert.NotBefore)
+}
+
+// simulateChain re-runs the certificate and chain checks of the stored chain at the date
+func (mon Monitor) simulateChain(st DBStateRow, certs []*x509.Certificate, date time.Time) []SimulatedCause {
+	causes := make([]SimulatedCause, 0)
+
+	sni := st.SNI
+	for _, cert := range certs {
+		if err := checkCertificateAt(cert, sni, date); err != nil {
+			causes = append(causes, simulatedCause(cert, true, err.Error()))
+		}
+		sni = ""
+	}
+
+	roots := mon.Cfg.Roots(st.Host, st.SNI)
+	problems, err := CheckChain(certs, roots, date)
+	if err == nil {
+		return causes
+	}
+	broken := false
+	for _, problem := range problems {
+		broken = broken || (problem != ChainWrongOrder && problem != ChainExtraCerts)
+	}
+	if !broken || len(causes) != 0 {
+		return causes
+	}
+
+	// the served certificates are valid, look for the expiring issuers from the trust store
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, _ := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	seen := make(map[string]bool)
+	for _, chain := range chains {
+		for _, cert := range chain {
+			fp := fingerprint(cert.Raw)
+			if seen[fp] || !outOfValidity(cert, date) {
+				continue
+			}
+			seen[fp] = true
+			causes = append(causes, simulatedCause(cert, false,
+				fmt.Sprintf("Issuer %s is expired or inactive yet", certName(cert))))
+		}
+	}
+	if len(causes) == 0 {
+		causes = append(causes, SimulatedCause{Reason: err.Error()})
+	}
+	return causes
+}
+
+// Simulate returns the states which stored chains fail validation on the date,
+// revocation and pins are not checked
+func (mon Monitor) Simulate(date time.Time) []SimulatedState {
+	result := make([]SimulatedState, 0)
+	for _, st := range mon.DB.GetStatesBy("") {
+		ders := mon.DB.GetChainDERByStateID(st.ID)
+		if len(ders) == 0 {
+			continue
+		}
+		certs := make([]*x509.Certificate, 0, len(ders))
+		for _, der := range ders {
+			if cert, err := x509.ParseCertificate(der); err == nil {
+				certs = append(certs, cert)
+			}
+		}
+		if len(certs) != len(ders) {
+			continue
+		}
+		if causes := mon.simulateChain(st, certs, date); len(causes) != 0 {
+			result = append(result, SimulatedState{DBStateRow: st, Causes: causes})
+		}
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+package monitor
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestSimulateChain(t *testing.T) {
+	days := func(n int) time.Time { return time.Now().AddDate(0, 0, n) }
+
+	tests := []struct {
+		name       string
+		rootDays   int
+		interDays  int
+		leafDays   int
+		trusted    bool
+		sni        string
+		date       time.Time
+		wantCauses []string
+		wantServed []bool
+	}{
+		{"valid", 3650, 365, 90, true, "leaf.example.com", days(30), nil, nil},
+		{"leaf expires", 3650, 365, 90, true, "leaf.example.com", days(100),
+			[]string{"leaf.example.com"}, []bool{true}},
+		{"served intermediate expires", 3650, 60, 90, true, "leaf.example.com", days(70),
+			[]string{"Test Intermediate"}, []bool{true}},
+		{"leaf and intermediate expire", 3650, 60, 90, true, "leaf.example.com", days(100),
+			[]string{"leaf.example.com", "Test Intermediate"}, []bool{true, true}},
+		{"trusted root expires", 120, 365, 365, true, "leaf.example.com", days(130),
+			[]string{"Test Root"}, []bool{false}},
+		{"not active yet", 3650, 365, 90, true, "leaf.example.com", days(-1),
+			[]string{"leaf.example.com", "Test Intermediate"}, []bool{true, true}},
+		{"name mismatch", 3650, 365, 90, true, "www.example.com", days(30),
+			[]string{"leaf.example.com"}, []bool{true}},
+		{"untrusted root", 3650, 365, 90, false, "leaf.example.com", days(30),
+			[]string{""}, []bool{false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, rootKey := testCert(t, "Test Root", true, nil, nil, days(tt.rootDays))
+			inter, interKey := testCert(t, "Test Intermediate", true, root, rootKey, days(tt.interDays))
+			leaf, _ := testCert(t, "leaf.example.com", false, inter, interKey, days(tt.leafDays))
+			mon := testMonitor(t)
+			if tt.trusted {
+				roots := x509.NewCertPool()
+				roots.AddCert(root)
+				mon.Cfg.TrustBundle = "test"
+				mon.Cfg.roots["test"] = roots
+			}
+			st := DBStateRow{Host: tt.sni + ":443", SNI: tt.sni}
+			causes := mon.simulateChain(st, []*x509.Certificate{leaf, inter}, tt.date)
+			if len(causes) != len(tt.wantCauses) {
+				t.Fatalf("causes %+v, want %v", causes, tt.wantCauses)
+			}
+			for i, cause := range causes {
+				name := ""
+				if len(cause.Fingerprint) != 0 {
+					name = cause.CommonName
+				}
+				if name != tt.wantCauses[i] || cause.Served != tt.wantServed[i] || len(cause.Reason) == 0 {
+					t.Errorf("cause %q served %v (%s), want %q %v",
+						name, cause.Served, cause.Reason, tt.wantCauses[i], tt.wantServed[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ProtoTLS defines implicit TLS, handshake starts right after connect
+	ProtoTLS = "tls"
+	// ProtoSMTP defines SMTP with EHLO and STARTTLS
+	ProtoSMTP = "smtp"
+	// ProtoIMAP defines IMAP with STARTTLS
+	ProtoIMAP = "imap"
+	// ProtoPOP3 defines POP3 with STLS
+	ProtoPOP3 = "pop3"
+	// ProtoLDAP defines LDAP with StartTLS extended operation
+	ProtoLDAP = "ldap"
+	// ProtoXMPP defines XMPP client stream with STARTTLS
+	ProtoXMPP = "xmpp"
+	// ProtoFTP defines FTP with AUTH TLS
+	ProtoFTP = "ftp"
+	// ProtoPostgres defines PostgreSQL with SSLRequest
+	ProtoPostgres = "postgres"
+	// ProtoMySQL defines MySQL with SSL capability handshake
+	ProtoMySQL = "mysql"
+	// ProtoRDP defines RDP with X.224 TLS negotiation
+	ProtoRDP = "rdp"
+
+	ehloName        = "localhost"
+	maxPreambleSize = 64 * 1024
+	ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+	postgresSSLRequest = 80877103
+
+	mysqlClientProtocol41       = 0x00000200
+	mysqlClientSSL              = 0x00000800
+	mysqlClientSecureConnection = 0x00008000
+	mysqlCharsetUTF8            = 33
+
+	rdpProtocolSSL    = 0x00000001
+	rdpProtocolHybrid = 0x00000002
+	rdpNegResponse    = 0x02
+	rdpNegFailure     = 0x03
+)
+
+// negotiator prepares plain connection to TLS handshake
+type negotiator func(conn net.Conn, sni string) error
+
+var (
+	negotiators = map[string]negotiator{
+		ProtoTLS:  func(net.Conn, string) error { return nil },
+		ProtoSMTP: negotiateSMTP,
+		ProtoIMAP: negotiateIMAP,
+		ProtoPOP3: negotiatePOP3,
+		ProtoLDAP: negotiateLDAP,
+		ProtoXMPP: negotiateXMPP,
+		ProtoFTP:  negotiateFTP,
+
+		ProtoPostgres: negotiatePostgres,
+		ProtoMySQL:    negotiateMySQL,
+		ProtoRDP:      negotiateRDP,
+	}
+	// well-known ports which are served with STARTTLS
+	portProtocols = map[int]string{
+		21:   ProtoFTP,
+		25:   ProtoSMTP,
+		110:  ProtoPOP3,
+		143:  ProtoIMAP,
+		389:  ProtoLDAP,
+		587:  ProtoSMTP,
+		3306: ProtoMySQL,
+		3389: ProtoRDP,
+		5222: ProtoXMPP,
+		5432: ProtoPostgres,
+	}
+)
+
+// ValidProtocol reports whether the protocol is supported
+func ValidProtocol(proto string) bool {
+	_, ok := negotiators[proto]
+	return ok
+}
+
+// protocolByPort returns the protocol for the host, when it is not specified explicitly
+func protocolByPort(host string) string {
+	_, p, err := net.SplitHostPort(host)
+	if err != nil {
+		return ProtoTLS
+	}
+	port, _ := strconv.Atoi(p)
+	if proto, ok := portProtocols[port]; ok {
+		return proto
+	}
+	return ProtoTLS
+}
+
+// startTLS negotiates TLS over the plain connection according to the protocol
+func startTLS(conn net.Conn, proto string, sni string) error {
+	negotiate, ok := negotiators[proto]
+	if !ok {
+		return fmt.Errorf("Unsupported protocol %s", proto)
+	}
+	return negotiate(conn, sni)
+}
+
+func writeLine(conn net.Conn, line string) error {
+	_, err := io.WriteString(conn, line+"\r\n")
+	return err
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readReply reads SMTP/FTP-like multiline reply and checks its code
+func readReply(r *bufio.Reader, code string) ([]string, error) {
+	lines := make([]string, 0, 1)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return lines, err
+		}
+		if !strings.HasPrefix(line, code) {
+			return lines, fmt.Errorf("Unexpected reply %q, expected %s", line, code)
+		}
+		lines = append(lines, line[len(code):])
+		if len(line) == len(code) || line[len(code)] != '-' {
+			return lines, nil
+		}
+	}
+}
+
+func negotiateSMTP(conn net.Conn, sni string) error {
+	r := bufio.NewReader(conn)
+	if _, err := readReply(r, "220"); err != nil {
+		return err
+	}
+	if err := writeLine(conn, "EHLO "+ehloName); err != nil {
+		return err
+	}
+	extensions, err := readReply(r, "250")
+	if err != nil {
+		return err
+	}
+	supported := false
+	for _, ext := range extensions {
+		if strings.EqualFold(strings.TrimLeft(ext, "- "), "STARTTLS") {
+			supported = true
+		}
+	}
+	if !supported {
+		return errors.New("SMTP server does not support STARTTLS")
+	}
+	if err := writeLine(conn, "STARTTLS"); err != nil {
+		return err
+	}
+	_, err = readReply(r, "220")
+	return err
+}
+
+func negotiateIMAP(conn net.Conn, sni string) error {
+	r := bufio.NewReader(conn)
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "* OK") {
+		return fmt.Errorf("Unexpected IMAP greeting %q", line)
+	}
+	if err := writeLine(conn, "a1 STARTTLS"); err != nil {
+		return err
+	}
+	for {
+		if line, err = readLine(r); err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "a1 ") {
+			break
+		}
+	}
+	if !strings.HasPrefix(line, "a1 OK") {
+		return fmt.Errorf("IMAP server rejected STARTTLS: %q", line)
+	}
+	return nil
+}
+
+func negotiatePOP3(conn net.Conn, sni string) error {
+	r := bufio.NewReader(conn)
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("Unexpected POP3 greeting %q", line)
+	}
+	if err := writeLine(conn, "STLS"); err != nil {
+		return err
+	}
+	if line, err = readLine(r); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("POP3 server rejected STLS: %q", line)
+	}
+	return nil
+}
+
+func negotiateFTP(conn net.Conn, sni string) error {
+	r := bufio.NewReader(conn)
+	if _, err := readReply(r, "220"); err != nil {
+		return err
+	}
+	if err := writeLine(conn, "AUTH TLS"); err != nil {
+		return err
+	}
+	_, err := readReply(r, "234")
+	return err
+}
+
+// readUntil reads the stream until one of the markers is found
+func readUntil(conn net.Conn, markers ...string) (string, error) {
+	buf := make([]byte, 0, 1024)
+	chunk := make([]byte, 1024)
+	for len(buf) < maxPreambleSize {
+		n, err := conn.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		for _, marker := range markers {
+			if bytes.Contains(buf, []byte(marker)) {
+				return marker, nil
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("Too long preamble")
+}
+
+func negotiateXMPP(conn net.Conn, sni string) error {
+	stream := fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%s' version='1.0' "+
+		"xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams'>", sni)
+	if _, err := io.WriteString(conn, stream); err != nil {
+		return err
+	}
+	if _, err := readUntil(conn, "</stream:features>"); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		return err
+	}
+	marker, err := readUntil(conn, "<proceed", "<failure")
+	if err != nil {
+		return err
+	}
+	if marker != "<proceed" {
+		return errors.New("XMPP server rejected STARTTLS")
+	}
+	return nil
+}
+
+// readBER reads single BER element and returns its tag and value
+func readBER(r io.Reader) (tag byte, value []byte, err error) {
+	hdr := make([]byte, 2)
+	if _, err = io.ReadFull(r, hdr); err != nil {
+		return
+	}
+	tag = hdr[0]
+	length := int(hdr[1])
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size == 0 || size > 3 {
+			return tag, nil, errors.New("Unsupported BER length")
+		}
+		lb := make([]byte, size)
+		if _, err = io.ReadFull(r, lb); err != nil {
+			return
+		}
+		length = 0
+		for _, b := range lb {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxPreambleSize {
+		return tag, nil, errors.New("Too long BER element")
+	}
+	value = make([]byte, length)
+	_, err = io.ReadFull(r, value)
+	return
+}
+
+func negotiateLDAP(conn net.Conn, sni string) error {
+	// LDAPMessage { messageID 1, ExtendedRequest { requestName StartTLS OID } }
+	oid := []byte(ldapStartTLSOID)
+	request := append([]byte{0x80, byte(len(oid))}, oid...)
+	request = append([]byte{0x77, byte(len(request))}, request...)
+	request = append([]byte{0x02, 0x01, 0x01}, request...)
+	request = append([]byte{0x30, byte(len(request))}, request...)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	tag, msg, err := readBER(conn)
+	if err != nil {
+		return err
+	}
+	if tag != 0x30 {
+		return errors.New("Unexpected LDAP response")
+	}
+	r := bytes.NewReader(msg)
+	// skip messageID
+	if _, _, err := readBER(r); err != nil {
+		return err
+	}
+	// ExtendedResponse is [APPLICATION 24]
+	tag, resp, err := readBER(r)
+	if err != nil {
+		return err
+	}
+	if tag != 0x78 {
+		return errors.New("Unexpected LDAP response operation")
+	}
+	tag, code, err := readBER(bytes.NewReader(resp))
+	if err != nil {
+		return err
+	}
+	if tag != 0x0a || len(code) != 1 || code[0] != 0 {
+		return fmt.Errorf("LDAP server rejected StartTLS, result code %v", code)
+	}
+	return nil
+}
+
+func negotiatePostgres(conn net.Conn, sni string) error {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:], 8)
+	binary.BigEndian.PutUint32(request[4:], postgresSSLRequest)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 'S' {
+		return fmt.Errorf("PostgreSQL server rejected SSLRequest: %q", reply[0])
+	}
+	return nil
+}
+
+// readMySQLPacket reads single MySQL packet and returns its sequence number and payload
+func readMySQLPacket(r io.Reader) (seq byte, payload []byte, err error) {
+	hdr := make([]byte, 4)
+	if _, err = io.ReadFull(r, hdr); err != nil {
+		return
+	}
+	length := int(hdr[0]) | int(hdr[1])<<8 | int(hdr[2])<<16
+	if length > maxPreambleSize {
+		return hdr[3], nil, errors.New("Too long MySQL packet")
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	return hdr[3], payload, err
+}
+
+func negotiateMySQL(conn net.Conn, sni string) error {
+	seq, greeting, err := readMySQLPacket(conn)
+	if err != nil {
+		return err
+	}
+	if len(greeting) == 0 || greeting[0] == 0xff {
+		return errors.New("MySQL server refused connection")
+	}
+	if greeting[0] != 10 {
+		return fmt.Errorf("Unsupported MySQL protocol version %d", greeting[0])
+	}
+	// protocol version, server version, connection id, auth-plugin-data-part-1, filler
+	pos := bytes.IndexByte(greeting[1:], 0)
+	if pos < 0 {
+		return errors.New("Malformed MySQL greeting")
+	}
+	pos = 1 + pos + 1 + 4 + 8 + 1
+	if len(greeting) < pos+2 {
+		return errors.New("Malformed MySQL greeting")
+	}
+	if binary.LittleEndian.Uint16(greeting[pos:])&mysqlClientSSL == 0 {
+		return errors.New("MySQL server does not support SSL")
+	}
+
+	// SSLRequest: capability flags, max packet size, character set, reserved
+	request := make([]byte, 4+32)
+	request[0] = 32
+	request[3] = seq + 1
+	binary.LittleEndian.PutUint32(request[4:], mysqlClientSSL|mysqlClientProtocol41|mysqlClientSecureConnection)
+	binary.LittleEndian.PutUint32(request[8:], 1<<24-1)
+	request[12] = mysqlCharsetUTF8
+	_, err = conn.Write(request)
+	return err
+}
+
+func negotiateRDP(conn net.Conn, sni string) error {
+	// TPKT header, X.224 Connection Request, RDP Negotiation Request
+	request := []byte{
+		0x03, 0x00, 0x00, 0x13,
+		0x0e, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	binary.LittleEndian.PutUint32(request[15:], rdpProtocolSSL|rdpProtocolHybrid)
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return err
+	}
+	length := int(binary.BigEndian.Uint16(hdr[2:]))
+	if hdr[0] != 0x03 || length < 4 || length > maxPreambleSize {
+		return errors.New("Unexpected RDP response")
+	}
+	reply := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	// X.224 Connection Confirm is followed by RDP Negotiation Response
+	if len(reply) < 7 || reply[1]&0xf0 != 0xd0 {
+		return errors.New("Unexpected X.224 response")
+	}
+	if len(reply) < 15 {
+		return errors.New("RDP server supports only standard RDP security")
+	}
+	switch reply[7] {
+	case rdpNegResponse:
+		if binary.LittleEndian.Uint32(reply[11:])&(rdpProtocolSSL|rdpProtocolHybrid) == 0 {
+			return errors.New("RDP server selected standard RDP security")
+		}
+		return nil
+	case rdpNegFailure:
+		return fmt.Errorf("RDP server rejected TLS, failure code %d", binary.LittleEndian.Uint32(reply[11:]))
+	}
+	return errors.New("Unexpected RDP negotiation response")
+}
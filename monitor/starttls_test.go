@@ -0,0 +1,154 @@
+package monitor
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// lineServer writes the greeting and answers every line of the client with the next reply
+func lineServer(greeting string, replies ...string) func(net.Conn) {
+	return func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		if len(greeting) != 0 {
+			if _, err := io.WriteString(conn, greeting); err != nil {
+				return
+			}
+		}
+		for _, reply := range replies {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			if _, err := io.WriteString(conn, reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// xmppServer answers the stream header with the features and STARTTLS with the reply
+func xmppServer(reply string) func(net.Conn) {
+	return func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		if _, err := r.ReadString('>'); err != nil {
+			return
+		}
+		if _, err := r.ReadString('>'); err != nil {
+			return
+		}
+		io.WriteString(conn, "<stream:stream id='1'><stream:features>"+
+			"<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/></stream:features>")
+		if _, err := r.ReadString('>'); err != nil {
+			return
+		}
+		io.WriteString(conn, reply)
+	}
+}
+
+// binaryServer reads the request of the client and writes the reply
+func binaryServer(request int, reply []byte) func(net.Conn) {
+	return func(conn net.Conn) {
+		if _, err := io.ReadFull(conn, make([]byte, request)); err != nil {
+			return
+		}
+		conn.Write(reply)
+	}
+}
+
+// ldapReply returns ExtendedResponse to StartTLS with the result code
+func ldapReply(code byte) []byte {
+	return []byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, code, 0x04, 0x00, 0x04, 0x00}
+}
+
+// mysqlServer sends the greeting with the capability flags and reads SSLRequest
+func mysqlServer(capabilities uint16) func(net.Conn) {
+	return func(conn net.Conn) {
+		greeting := append([]byte{10}, "8.0.36\x00"...)
+		greeting = append(greeting, 1, 0, 0, 0)
+		greeting = append(greeting, "12345678\x00"...)
+		greeting = append(greeting, byte(capabilities), byte(capabilities>>8))
+		packet := append([]byte{byte(len(greeting)), 0, 0, 0}, greeting...)
+		if _, err := conn.Write(packet); err != nil {
+			return
+		}
+		io.ReadFull(conn, make([]byte, 4+32))
+	}
+}
+
+// rdpReply returns X.224 Connection Confirm with RDP Negotiation Response or Failure
+func rdpReply(negotiation byte, value byte) []byte {
+	return []byte{
+		0x03, 0x00, 0x00, 0x13,
+		0x0e, 0xd0, 0x00, 0x00, 0x12, 0x34, 0x00,
+		negotiation, 0x00, 0x08, 0x00, value, 0x00, 0x00, 0x00,
+	}
+}
+
+// runNegotiator runs the negotiator against the server over the pipe
+func runNegotiator(negotiate negotiator, server func(net.Conn)) error {
+	client, srv := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer srv.Close()
+		server(srv)
+	}()
+	err := negotiate(client, "mail.example.com")
+	client.Close()
+	<-done
+	return err
+}
+
+func TestNegotiators(t *testing.T) {
+	tests := []struct {
+		name      string
+		negotiate negotiator
+		server    func(net.Conn)
+		wantErr   string
+	}{
+		{"smtp", negotiateSMTP, lineServer("220 mx ESMTP\r\n",
+			"250-mx\r\n250-PIPELINING\r\n250 STARTTLS\r\n", "220 Ready\r\n"), ""},
+		{"smtp without starttls", negotiateSMTP, lineServer("220-mx\r\n220 ESMTP\r\n",
+			"250-mx\r\n250 8BITMIME\r\n"), "does not support STARTTLS"},
+		{"smtp rejected greeting", negotiateSMTP, lineServer("554 No service\r\n"), "Unexpected reply"},
+		{"smtp rejected starttls", negotiateSMTP, lineServer("220 mx\r\n",
+			"250 STARTTLS\r\n", "454 TLS not available\r\n"), "Unexpected reply"},
+		{"imap", negotiateIMAP, lineServer("* OK IMAP ready\r\n",
+			"* CAPABILITY IMAP4rev1\r\na1 OK Begin TLS\r\n"), ""},
+		{"imap rejected", negotiateIMAP, lineServer("* OK IMAP ready\r\n", "a1 NO STARTTLS\r\n"), "rejected"},
+		{"imap greeting", negotiateIMAP, lineServer("* BYE\r\n"), "Unexpected IMAP greeting"},
+		{"pop3", negotiatePOP3, lineServer("+OK POP3 ready\r\n", "+OK Begin TLS\r\n"), ""},
+		{"pop3 rejected", negotiatePOP3, lineServer("+OK POP3 ready\r\n", "-ERR STLS\r\n"), "rejected"},
+		{"ftp", negotiateFTP, lineServer("220-Welcome\r\n220 FTP ready\r\n", "234 AUTH TLS OK\r\n"), ""},
+		{"ftp rejected", negotiateFTP, lineServer("220 FTP ready\r\n", "500 Unknown\r\n"), "Unexpected reply"},
+		{"xmpp", negotiateXMPP, xmppServer("<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"), ""},
+		{"xmpp rejected", negotiateXMPP, xmppServer("<failure xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"),
+			"rejected"},
+		{"ldap", negotiateLDAP, binaryServer(31, ldapReply(0)), ""},
+		{"ldap rejected", negotiateLDAP, binaryServer(31, ldapReply(0x34)), "rejected"},
+		{"ldap closed", negotiateLDAP, binaryServer(31, nil), "EOF"},
+		{"postgres", negotiatePostgres, binaryServer(8, []byte{'S'}), ""},
+		{"postgres rejected", negotiatePostgres, binaryServer(8, []byte{'N'}), "rejected"},
+		{"mysql", negotiateMySQL, mysqlServer(mysqlClientSSL | mysqlClientProtocol41), ""},
+		{"mysql without ssl", negotiateMySQL, mysqlServer(mysqlClientProtocol41), "does not support SSL"},
+		{"mysql refused", negotiateMySQL, binaryServer(0, []byte{3, 0, 0, 0, 0xff, 0x10, 0x04}), "refused"},
+		{"rdp", negotiateRDP, binaryServer(19, rdpReply(rdpNegResponse, rdpProtocolSSL)), ""},
+		{"rdp hybrid", negotiateRDP, binaryServer(19, rdpReply(rdpNegResponse, rdpProtocolHybrid)), ""},
+		{"rdp standard security", negotiateRDP, binaryServer(19, rdpReply(rdpNegResponse, 0)),
+			"standard RDP security"},
+		{"rdp rejected", negotiateRDP, binaryServer(19, rdpReply(rdpNegFailure, 2)), "failure code 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runNegotiator(tt.negotiate, tt.server)
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Fatalf("negotiation is failed: %v", err)
+			}
+			if len(tt.wantErr) != 0 && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,6 +3,17 @@ const url=window.location.origin
 var certsTable = null;
 var statesTable = null;
 
+function sniLink(sni) {
+    host = sni.indexOf(':') >= 0 ? `[${sni}]` : sni
+    return `<a href="https://${host}">${sni}</a>`
+}
+
+const SeverityClassMap = {
+    'warning': 'warning',
+    'critical': 'danger',
+    'expired': 'danger'
+}
+
 const StateValueMap = {
     '0': 'Invalid',
     '1': 'Valid',
@@ -18,11 +29,13 @@ function loadCerts() {
             for (el of resp) {
                 certsTable.row.add([
                     el['commonName'],
-                    el['fingerprint'],
+                    `<a href="${url}/certs/${el['id']}/download">${el['fingerprint']}</a>`,
                     el['subjectHash'],
                     el['issuerHash'],
                     el['domains'],
-                    el['expired']
+                    el['expired'],
+                    el['severity'],
+                    el['class']
                 ]).draw(false);
             }
         }
@@ -38,9 +51,18 @@ function loadStates() {
             for (el of resp) {
                 statesTable.row.add([
                     el['host'],
-                    `<a href="https://${el['sni']}">${el['sni']}</a>`,
-                    StateValueMap[(el['valid']).toString()],
-                    el['description']
+                    sniLink(el['sni']),
+                    el['protocol'],
+                    StateValueMap[(el['valid']).toString()] +
+                        (el['errorCategory'] ? ` (${el['errorCategory']})` : ''),
+                    el['severity'],
+                    el['chain'] + (el['distrusted'] ? ',distrusted' : '') +
+                        (el['dane'] ? `,dane:${el['dane']}` : ''),
+                    (el['weakTLS'] ? 'Weak: ' : '') +
+                        `${el['tlsVersion']} ${el['cipherSuite']} ${el['alpn']} (${el['latency']} ms)` +
+                        (el['httpStatus'] ? ` ${el['httpVersion']} ${el['httpStatus']}` +
+                            (el['hsts'] ? ' HSTS' : ' no HSTS') + `, port 80: ${el['httpRedirect']}` : ''),
+                    el['error'] || el['description']
                 ]).draw(false);
             }
         }
@@ -56,9 +78,10 @@ function loadStatecerts() {
             for (el of resp) {
                 for (cert of el.certificates) {                
                     statecertsTable.row.add({
-                        "host": `${el["host"]} > <a href="https://${el["sni"]}">${el['sni']}</a>`,
+                        "host": `${el["host"]} > ${sniLink(el["sni"])}`,
                         "type": el["type"],
                         "valid": StateValueMap[(el['valid']).toString()],
+                        "severity": el["severity"],
                         "description": el["description"],
                         "expired": cert["expired"],
                         "fingerprint": cert["fingerprint"],
@@ -96,9 +119,14 @@ function formatCert(d) {
 }
 
 function onlinecheck(query) {
+    proto = ""
+    if (query.indexOf("://") > 0) {
+        [proto, query] = query.split("://")
+    }
     args = query.split("/")
-    _url = url + '/check?host=' + args[0]
-    if (args.length > 1) _url += "&sni" + args[1]
+    _url = url + '/check?host=' + encodeURIComponent(args[0])
+    if (args.length > 1) _url += "&sni=" + encodeURIComponent(args[1])
+    if (proto.length > 0) _url += "&proto=" + proto
     $.ajax({
         'url': _url,
         'type': 'GET',
@@ -127,25 +155,140 @@ function onlinecheck(query) {
     })
 }
 
+function blastRadius(query) {
+    // SHA-1 fingerprints are 40 hex digits, SPKI hashes are SHA-256
+    param = query.length == 64 ? 'spki' : 'fingerprint'
+    $("#blast-summary").empty()
+    $("#blast-certificates").empty()
+    $("#blast-zones").empty()
+    $.ajax({
+        'url': url + `/blast?${param}=` + encodeURIComponent(query),
+        'type': 'GET',
+        'error': function(data) {
+            $("#blast-summary").text('Certificate is not found')
+        },
+        'success': function (data) {
+            resp = JSON.parse(data)
+            $("#blast-summary").html(
+                `SPKI hash: <i>${resp.spkiHash}</i>, states: <b>${resp.states}</b>` +
+                (resp.keyReuse ? `<div class="bg-danger">${resp.description}</div>` : '')
+            )
+            for (cert of resp.certificates) {
+                $("#blast-certificates").append($("<li>").html(
+                    `<b>${cert.commonName}</b> <i>${cert.fingerprint}</i> (${cert.severity})`
+                ))
+            }
+            for (zone of resp.zones) {
+                list = $("<ul>")
+                for (st of zone.states) {
+                    list.append($("<li>").html(
+                        `${st.host} > ${sniLink(st.sni)}: ${StateValueMap[st.valid.toString()]}, <i>${st.fingerprint}</i>`
+                    ))
+                }
+                $("#blast-zones").append($("<h5>").text(zone.name || '(no zone)'), list)
+            }
+        }
+    })
+}
+
+const SeverityBgMap = {
+    'warning': 'bg-warning',
+    'critical': 'bg-danger',
+    'expired': 'bg-danger'
+}
+
+function caNode(node) {
+    var name = node.commonName || node.subjectHash
+    var expiry = node.known ? `expires ${node.notAfter}` : 'not served'
+    var item = $("<li>").html(
+        `<b>${name}</b> ${node.states} states, ${node.leaves} leaves, ${expiry}` +
+        (node.earliestExpiry.startsWith('0001') ? '' : `, earliest beneath ${node.earliestExpiry}`)
+    )
+    if (node.severity in SeverityBgMap) {
+        item.addClass(SeverityBgMap[node.severity])
+    }
+    if (node.children.length > 0) {
+        var children = $("<ul>")
+        for (var child of node.children) {
+            children.append(caNode(child))
+        }
+        item.append(children)
+    }
+    return item
+}
+
+function loadCAGraph() {
+    $.ajax({
+        'url': url + '/cagraph',
+        'type': 'GET',
+        'success' : function (data) {
+            resp = JSON.parse(data);
+            $("#cagraph-tree").empty()
+            for (node of resp) {
+                $("#cagraph-tree").append(caNode(node))
+            }
+        }
+    });
+}
+
+function simulate(date) {
+    $("#simulate-states").empty()
+    $.ajax({
+        'url': url + '/simulate?date=' + encodeURIComponent(date),
+        'type': 'GET',
+        'success' : function (data) {
+            resp = JSON.parse(data);
+            if (resp.length == 0) {
+                $("#simulate-states").append($("<li>").text('No state fails validation'))
+            }
+            for (el of resp) {
+                causes = $("<ul>")
+                for (cause of el.causes) {
+                    causes.append($("<li>").html(
+                        `${cause.reason}` + (cause.fingerprint ? ` <i>${cause.fingerprint}</i> (${cause.notAfter})` : '')
+                    ))
+                }
+                $("#simulate-states").append(
+                    $("<li>").html(`<b>${el.host} > ${sniLink(el.sni)}</b>`).append(causes)
+                )
+            }
+        }
+    });
+}
+
 $(document).ready(function() {
     $("#btn-onlinecheck").click(function () {
         onlinecheck($("#onlinecheck-query").val())
     })
+    $("#btn-simulate").click(function () {
+        simulate($("#simulate-date").val())
+    })
+    $("#btn-blast").click(function () {
+        blastRadius($("#blast-query").val().trim())
+    })
     certsTable = $('#certsTable').DataTable({
         "createdRow": function(row, data, dataIndex) {
-            if( data[4] < 35 ){
-                $(row).addClass('danger');
+            if( data[6] in SeverityClassMap ){
+                $(row).addClass(SeverityClassMap[data[6]]);
             }
         }
     });
     statesTable = $('#statesTable').DataTable({
         "createdRow": function(row, data, dataIndex) {
-            if( data[2] == 'Invalid' ){
-                $(row).addClass('danger');
+            if( data[4] in SeverityClassMap ){
+                $(row).addClass(SeverityClassMap[data[4]]);
+            }
+            if( data[6].startsWith('Weak') ){
+                $('td', row).eq(6).addClass('warning');
             }
         }
     });
     statecertsTable = $('#statecertsTable').DataTable({
+        "createdRow": function(row, data, dataIndex) {
+            if( data.severity in SeverityClassMap ){
+                $(row).addClass(SeverityClassMap[data.severity]);
+            }
+        },
         "columns": [
             {
                 "className": "details-control",
@@ -157,6 +300,7 @@ $(document).ready(function() {
             {"data": "fingerprint"},
             {"data": "type"},
             {"data": "valid"},
+            {"data": "severity"},
             {"data": "description"},
             {"data": "expired"}
         ]
@@ -164,6 +308,7 @@ $(document).ready(function() {
     loadCerts();
     loadStates();
     loadStatecerts();
+    loadCAGraph();
     $('a[data-toggle="tab"]').on('shown.bs.tab', function(e){
         $($.fn.dataTable.tables(true)).DataTable()
            .columns.adjust();